@@ -0,0 +1,111 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReconnectDelay is returned by sends while the client waits out the
+// delay before dialing again, see WithReconnectDelay
+var ErrReconnectDelay = errors.New("waiting to reconnect")
+
+// backoff spaces out dialing after failures, guarded by hc.mu
+type backoff struct {
+	// min and max bound the delay, which doubles with every failure, min 0
+	// dials whenever a flush needs to
+	min, max time.Duration
+	// eager dials as soon as the delay is up, rather than with the next
+	// flush
+	eager bool
+
+	delay time.Duration
+	next  time.Time
+	// timer is set while an eager reconnect is scheduled
+	timer Ticker
+}
+
+// waiting reports whether now is too early to dial
+func (b *backoff) waiting(now time.Time) bool {
+	return b.min > 0 && now.Before(b.next)
+}
+
+// dialed records the outcome of dialing at now
+func (b *backoff) dialed(err error, now time.Time) {
+	if b.min <= 0 {
+		return
+	}
+	if err == nil {
+		b.delay, b.next = 0, time.Time{}
+		return
+	}
+	b.delay *= 2
+	if b.delay < b.min {
+		b.delay = b.min
+	}
+	if b.max > 0 && b.delay > b.max {
+		b.delay = b.max
+	}
+	b.next = now.Add(b.delay)
+}
+
+// scheduleReconnect dials again once the delay is up when reconnecting
+// eagerly, hc.mu must be held
+//
+// the delay is timed with the first tick of a ticker from the client's
+// Clock, so a test clock drives it like LogHeka's flushes
+func (hc *HekaClient) scheduleReconnect() {
+	b := &hc.backoff
+	if !b.eager || b.delay <= 0 || b.timer != nil {
+		return
+	}
+	t := hc.clock.NewTicker(b.delay)
+	b.timer = t
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.C():
+		case <-hc.stop:
+			return
+		}
+		hc.mu.Lock()
+		defer hc.mu.Unlock()
+		b.timer = nil
+		select {
+		case <-hc.stop:
+			return
+		default:
+		}
+		if hc.sender == nil {
+			hc.reconnect()
+		}
+	}()
+}
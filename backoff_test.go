@@ -0,0 +1,118 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"context"
+	"errors"
+	"github.com/rcrowley/go-metrics"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyDialer fails the first fails dials and then connects to a pipe
+type flakyDialer struct {
+	mu    sync.Mutex
+	fails int
+	dials int
+}
+
+func (d *flakyDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.dials <= d.fails {
+		return nil, errors.New("connection refused")
+	}
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (d *flakyDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+func TestEagerReconnectClock(t *testing.T) {
+	clock := newTestClock()
+	d := &flakyDialer{fails: 2}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithClock(clock), WithDialFunc(d.dial),
+		WithReconnectDelay(time.Second, time.Minute), WithEagerReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Stop()
+	if err = hc.Flush(metrics.NewRegistry()); err == nil {
+		t.Fatalf("expected the flush to fail")
+	}
+	if d.count() != 1 {
+		t.Fatalf("%d dials, want 1", d.count())
+	}
+
+	// nothing happens until the clock moves
+	time.Sleep(10 * time.Millisecond)
+	if d.count() != 1 {
+		t.Fatalf("redialed before the delay was up")
+	}
+	clock.advance(time.Second)
+	waitFor(t, "the second dial", func() bool { return d.count() == 2 })
+	waitFor(t, "the next reconnect", func() bool { return clock.running() == 2 })
+
+	// the second failure doubles the delay
+	clock.advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if d.count() != 2 {
+		t.Fatalf("redialed before the doubled delay was up")
+	}
+	clock.advance(time.Second)
+	waitFor(t, "the third dial", func() bool { return d.count() == 3 })
+	waitFor(t, "the connection", func() bool { return hc.Stats().Connected })
+}
+
+func TestEagerReconnectStop(t *testing.T) {
+	clock := newTestClock()
+	d := &flakyDialer{fails: 1}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithClock(clock), WithDialFunc(d.dial),
+		WithReconnectDelay(time.Second, 0), WithEagerReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc.Flush(metrics.NewRegistry())
+	hc.Stop()
+	time.Sleep(10 * time.Millisecond)
+	clock.advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if d.count() != 1 {
+		t.Errorf("redialed after Stop")
+	}
+}
@@ -0,0 +1,103 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"sync"
+)
+
+// BackpressureFunc is told when the messages waiting to be sent reach the
+// high-water mark, backedUp true, and when they're back down to the
+// low-water mark, backedUp false, with how many are waiting
+//
+// it's called from the flushing goroutine and must not block or flush
+type BackpressureFunc func(backedUp bool, pending int)
+
+// backpressure counts the messages built by flushes and not yet sent, which
+// pile up when sends are slow, e.g. to a stalled Heka, and Flush is called
+// while another flush is sending
+type backpressure struct {
+	high, low int
+	f         BackpressureFunc
+
+	mu       sync.Mutex
+	pending  int
+	backedUp bool
+	// drained is closed once pending is back to 0, see idle
+	drained chan struct{}
+}
+
+// waiting returns how many messages are waiting to be sent
+func (b *backpressure) waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending
+}
+
+// idle returns a channel closed once no messages are waiting to be sent
+func (b *backpressure) idle() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == 0 {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	if b.drained == nil {
+		b.drained = make(chan struct{})
+	}
+	return b.drained
+}
+
+// add adds n, which may be negative, to the pending messages and calls f
+// when they cross a mark
+func (b *backpressure) add(n int) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending += n
+	if b.pending == 0 && b.drained != nil {
+		close(b.drained)
+		b.drained = nil
+	}
+	if b.f == nil {
+		return
+	}
+	switch {
+	case !b.backedUp && b.pending >= b.high:
+		b.backedUp = true
+		b.f(true, b.pending)
+	case b.backedUp && b.pending <= b.low:
+		b.backedUp = false
+		b.f(false, b.pending)
+	}
+}
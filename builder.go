@@ -0,0 +1,132 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"time"
+)
+
+// ClientBuilder accumulates HekaClient settings through chained calls, an
+// alternative to passing Options to NewHekaClient
+//
+//	hc, err := hekametrics.Builder().
+//		Connect("tcp://127.0.0.1:5565").
+//		Type("stats").
+//		Severity(6).
+//		Percentiles(0.5, 0.99).
+//		Build()
+type ClientBuilder struct {
+	connect, msgtype string
+	opts             []Option
+}
+
+// Builder starts a new ClientBuilder
+func Builder() *ClientBuilder {
+	return &ClientBuilder{}
+}
+
+// Connect sets the Heka address, see NewHekaClient
+func (b *ClientBuilder) Connect(connect string) *ClientBuilder {
+	b.connect = connect
+	return b
+}
+
+// Type sets the 'Type' field on messages
+func (b *ClientBuilder) Type(msgtype string) *ClientBuilder {
+	b.msgtype = msgtype
+	return b
+}
+
+// Severity is the builder form of WithSeverity
+func (b *ClientBuilder) Severity(severity int32) *ClientBuilder {
+	return b.With(WithSeverity(severity))
+}
+
+// Hostname is the builder form of WithHostname
+func (b *ClientBuilder) Hostname(hostname string) *ClientBuilder {
+	return b.With(WithHostname(hostname))
+}
+
+// EnvVersion is the builder form of WithEnvVersion
+func (b *ClientBuilder) EnvVersion(v string) *ClientBuilder {
+	return b.With(WithEnvVersion(v))
+}
+
+// Logger is the builder form of WithLogger
+func (b *ClientBuilder) Logger(name string) *ClientBuilder {
+	return b.With(WithLogger(name))
+}
+
+// Percentiles is the builder form of WithPercentiles
+func (b *ClientBuilder) Percentiles(ps ...float64) *ClientBuilder {
+	return b.With(WithPercentiles(ps...))
+}
+
+// Interval is the builder form of WithInterval
+func (b *ClientBuilder) Interval(d time.Duration) *ClientBuilder {
+	return b.With(WithInterval(d))
+}
+
+// WriteTimeout is the builder form of WithWriteTimeout
+func (b *ClientBuilder) WriteTimeout(d time.Duration) *ClientBuilder {
+	return b.With(WithWriteTimeout(d))
+}
+
+// LogSuppression is the builder form of WithLogSuppression
+func (b *ClientBuilder) LogSuppression(d time.Duration) *ClientBuilder {
+	return b.With(WithLogSuppression(d))
+}
+
+// Include is the builder form of WithInclude
+func (b *ClientBuilder) Include(patterns ...string) *ClientBuilder {
+	return b.With(WithInclude(patterns...))
+}
+
+// Exclude is the builder form of WithExclude
+func (b *ClientBuilder) Exclude(patterns ...string) *ClientBuilder {
+	return b.With(WithExclude(patterns...))
+}
+
+// Field is the builder form of WithField
+func (b *ClientBuilder) Field(name string, value interface{}) *ClientBuilder {
+	return b.With(WithField(name, value))
+}
+
+// With adds arbitrary Options, for settings without a builder method
+func (b *ClientBuilder) With(opts ...Option) *ClientBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build creates the HekaClient, returning the first error from the
+// connect string or any setting
+func (b *ClientBuilder) Build() (*HekaClient, error) {
+	return NewHekaClient(b.connect, b.msgtype, b.opts...)
+}
@@ -0,0 +1,133 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+/*
+Command hekametrics-send sends metric values to Heka as a single message,
+for cron jobs and shell scripts
+
+values are given as name=value arguments, or read from stdin one per line
+as 'name value' or 'name=value' when there are no arguments:
+
+	hekametrics-send -connect tcp://127.0.0.1:5565 -type backup backup.bytes=1048576 backup.seconds=12.5
+	du -sb /var/lib/* | awk '{print "disk." $2, $1}' | hekametrics-send -type disk
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/imgix/hekametrics"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	var (
+		connect  = flag.String("connect", "tcp://127.0.0.1:5565", "Heka address, see hekametrics.NewHekaClient")
+		msgtype  = flag.String("type", "hekametrics-send", "'Type' field of the message")
+		hostname = flag.String("hostname", "", "override the 'Hostname' field")
+		severity = flag.Int("severity", hekametrics.DefaultSeverity, "'Severity' field of the message")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [name=value ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	r := metrics.NewRegistry()
+	var err error
+	if flag.NArg() > 0 {
+		for _, arg := range flag.Args() {
+			if err = register(r, arg); err != nil {
+				break
+			}
+		}
+	} else {
+		err = readValues(r, os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	opts := []hekametrics.Option{hekametrics.WithSeverity(int32(*severity))}
+	if *hostname != "" {
+		opts = append(opts, hekametrics.WithHostname(*hostname))
+	}
+	hc, err := hekametrics.NewHekaClient(*connect, *msgtype, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err = hc.Flush(r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// readValues registers a value for each non-blank line of in
+func readValues(r metrics.Registry, in io.Reader) error {
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if err := register(r, line); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// register parses 'name=value' or 'name value' into a gauge on r, integers
+// become a Gauge and anything else a GaugeFloat64
+func register(r metrics.Registry, pair string) error {
+	i := strings.IndexAny(pair, "= \t")
+	if i <= 0 {
+		return fmt.Errorf("%q: expected name=value", pair)
+	}
+	name, value := pair[:i], strings.TrimSpace(pair[i+1:])
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		g := metrics.NewGauge()
+		g.Update(n)
+		return r.Register(name, g)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q: value is not a number", pair)
+	}
+	g := metrics.NewGaugeFloat64()
+	g.Update(f)
+	return r.Register(name, g)
+}
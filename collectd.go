@@ -0,0 +1,216 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/binary"
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// collectd network protocol part types and limits
+const (
+	collectdHost           = 0x0000
+	collectdPlugin         = 0x0002
+	collectdPluginInstance = 0x0003
+	collectdType           = 0x0004
+	collectdTypeInstance   = 0x0005
+	collectdValues         = 0x0006
+	collectdTimeHR         = 0x0008
+	collectdIntervalHR     = 0x0009
+
+	collectdGauge = 1
+
+	// collectdPacketSize is collectd's default network buffer size
+	collectdPacketSize = 1452
+	// collectdNameLen is the longest name collectd accepts
+	collectdNameLen = 63
+)
+
+// encodeCollectd renders the metric fields of msg in collectd's binary
+// network protocol, one GAUGE value per field
+//
+// plugin is 'hekametrics', the metric name is the plugin instance and the
+// statistic the type instance, e.g. 'api.latency.timer.mean' is
+// hekametrics-api.latency.timer/gauge-mean
+//
+// go-metrics values are already aggregated, so counts are sent as gauges too
+// rather than having collectd derive a rate from them
+func encodeCollectd(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	e := collectdEncoder{
+		b:        (*buf)[:0],
+		host:     msg.GetHostname(),
+		time:     uint64(float64(msg.GetTimestamp()) / 1e9 * (1 << 30)),
+		interval: uint64(hc.interval.Seconds() * (1 << 30)),
+	}
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		var v float64
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if len(f.GetValueInteger()) == 0 {
+				continue
+			}
+			v = float64(f.GetValueInteger()[0])
+		case message.Field_DOUBLE:
+			if len(f.GetValueDouble()) == 0 {
+				continue
+			}
+			v = f.GetValueDouble()[0]
+		default:
+			continue
+		}
+		name := f.GetName()
+		instance, stat := "", name
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			instance, stat = name[:i], name[i+1:]
+		}
+		e.value(instance, stat, v)
+	}
+	*buf = e.b
+	return nil
+}
+
+// collectdEncoder writes parts, starting a new packet with the identifying
+// parts repeated whenever the current one would exceed collectdPacketSize
+type collectdEncoder struct {
+	b              []byte
+	start          int
+	host           string
+	time, interval uint64
+	instance       string
+}
+
+func (e *collectdEncoder) value(instance, stat string, v float64) {
+	instance = collectdName(instance)
+	stat = collectdName(stat)
+	size := 4 + len(stat) + 1 + 4 + 2 + 1 + 8
+	if instance != e.instance {
+		size += 4 + len(instance) + 1
+	}
+	if len(e.b) == e.start || len(e.b)-e.start+size > collectdPacketSize {
+		e.start = len(e.b)
+		e.str(collectdHost, e.host)
+		e.num(collectdTimeHR, e.time)
+		e.num(collectdIntervalHR, e.interval)
+		e.str(collectdPlugin, "hekametrics")
+		e.str(collectdType, "gauge")
+		e.instance = ""
+		e.str(collectdPluginInstance, instance)
+	} else if instance != e.instance {
+		e.str(collectdPluginInstance, instance)
+	}
+	e.instance = instance
+	e.str(collectdTypeInstance, stat)
+
+	e.header(collectdValues, 4+2+1+8)
+	e.b = append(e.b, 0, 1, collectdGauge)
+	var n [8]byte
+	// gauges are the one little endian value in the protocol
+	binary.LittleEndian.PutUint64(n[:], math.Float64bits(v))
+	e.b = append(e.b, n[:]...)
+}
+
+func (e *collectdEncoder) header(typ, length int) {
+	e.b = append(e.b, byte(typ>>8), byte(typ), byte(length>>8), byte(length))
+}
+
+func (e *collectdEncoder) str(typ int, s string) {
+	e.header(typ, 4+len(s)+1)
+	e.b = append(e.b, s...)
+	e.b = append(e.b, 0)
+}
+
+func (e *collectdEncoder) num(typ int, v uint64) {
+	e.header(typ, 4+8)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], v)
+	e.b = append(e.b, n[:]...)
+}
+
+// collectdName replaces the '/' collectd uses to join identifiers and
+// truncates to collectdNameLen, on a rune boundary
+func collectdName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == 0 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > collectdNameLen {
+		cut := collectdNameLen
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return s
+}
+
+func dialCollectd(hc *HekaClient, u *url.URL) (Sender, error) {
+	s, err := dialSender(hc, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return collectdSender{s}, nil
+}
+
+// collectdSender sends each packet written by collectdEncoder as its own
+// datagram, splitting where a Host part starts
+type collectdSender struct {
+	Sender
+}
+
+func (s collectdSender) SendMessage(b []byte) error {
+	start := 0
+	for pos := 0; pos+4 <= len(b); {
+		typ := int(b[pos])<<8 | int(b[pos+1])
+		length := int(b[pos+2])<<8 | int(b[pos+3])
+		if typ == collectdHost && pos > start {
+			if err := s.Sender.SendMessage(b[start:pos]); err != nil {
+				return err
+			}
+			start = pos
+		}
+		if length < 4 {
+			break
+		}
+		pos += length
+	}
+	if start < len(b) {
+		return s.Sender.SendMessage(b[start:])
+	}
+	return nil
+}
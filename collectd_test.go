@@ -0,0 +1,153 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// collectdPart is a part of an encoded collectd packet
+type collectdPart struct {
+	typ  int
+	body []byte
+}
+
+// collectdParts splits b into its parts, failing on bad lengths
+func collectdParts(t *testing.T, b []byte) []collectdPart {
+	var parts []collectdPart
+	for len(b) > 0 {
+		if len(b) < 4 {
+			t.Fatalf("%d trailing bytes", len(b))
+		}
+		typ := int(binary.BigEndian.Uint16(b))
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			t.Fatalf("part %#04x: bad length %d, %d bytes left", typ, length, len(b))
+		}
+		parts = append(parts, collectdPart{typ, b[4:length]})
+		b = b[length:]
+	}
+	return parts
+}
+
+func TestCollectdEncoder(t *testing.T) {
+	e := collectdEncoder{host: "web1", time: 3 << 30, interval: 10 << 30}
+	e.value("api.latency.timer", "mean", 1.5)
+	e.value("api.latency.timer", "max", 2)
+	e.value("db", "count", 7)
+
+	want := []byte{
+		0x00, 0x00, 0x00, 0x09, 'w', 'e', 'b', '1', 0, // host
+		0x00, 0x08, 0x00, 0x0c, 0, 0, 0, 0, 0xc0, 0, 0, 0, // time, 3s << 30
+		0x00, 0x09, 0x00, 0x0c, 0, 0, 0, 0x02, 0x80, 0, 0, 0, // interval, 10s << 30
+		0x00, 0x02, 0x00, 0x10, 'h', 'e', 'k', 'a', 'm', 'e', 't', 'r', 'i', 'c', 's', 0, // plugin
+		0x00, 0x04, 0x00, 0x0a, 'g', 'a', 'u', 'g', 'e', 0, // type
+	}
+	want = append(want, 0x00, 0x03, 0x00, 0x16)
+	want = append(append(want, "api.latency.timer"...), 0)
+	want = append(append(append(want, 0x00, 0x05, 0x00, 0x09), "mean"...), 0)
+	want = append(want, 0x00, 0x06, 0x00, 0x0f, 0x00, 0x01, collectdGauge)
+	want = append(want, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f) // 1.5, little endian
+	// the plugin instance isn't repeated for the same metric
+	want = append(append(append(want, 0x00, 0x05, 0x00, 0x08), "max"...), 0)
+	want = append(want, 0x00, 0x06, 0x00, 0x0f, 0x00, 0x01, collectdGauge)
+	want = append(want, 0, 0, 0, 0, 0, 0, 0x00, 0x40) // 2
+	want = append(append(append(want, 0x00, 0x03, 0x00, 0x07), "db"...), 0)
+	want = append(append(append(want, 0x00, 0x05, 0x00, 0x0a), "count"...), 0)
+	want = append(want, 0x00, 0x06, 0x00, 0x0f, 0x00, 0x01, collectdGauge)
+	want = append(want, 0, 0, 0, 0, 0, 0, 0x1c, 0x40) // 7
+
+	if !bytes.Equal(e.b, want) {
+		t.Fatalf("encoded\n%x\nwant\n%x", e.b, want)
+	}
+	collectdParts(t, e.b)
+}
+
+func TestCollectdEncoderPackets(t *testing.T) {
+	e := collectdEncoder{host: "web1"}
+	for i := 0; i < 200; i++ {
+		e.value(strings.Repeat("m", 40), "count", float64(i))
+	}
+	rec := &recordingSender{}
+	if err := (collectdSender{rec}).SendMessage(e.b); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.sent) < 2 {
+		t.Fatalf("expected several packets, got %d", len(rec.sent))
+	}
+	n := 0
+	for i, packet := range rec.sent {
+		if len(packet) > collectdPacketSize {
+			t.Errorf("packet %d: %d bytes, over %d", i, len(packet), collectdPacketSize)
+		}
+		parts := collectdParts(t, packet)
+		if parts[0].typ != collectdHost || string(parts[0].body) != "web1\x00" {
+			t.Errorf("packet %d doesn't start with the host: %#04x %q", i, parts[0].typ, parts[0].body)
+		}
+		seenInstance := false
+		for _, p := range parts {
+			switch p.typ {
+			case collectdPluginInstance:
+				seenInstance = true
+			case collectdValues:
+				if !seenInstance {
+					t.Errorf("packet %d: values before a plugin instance", i)
+				}
+				v := math.Float64frombits(binary.LittleEndian.Uint64(p.body[3:]))
+				if v != float64(n) {
+					t.Errorf("packet %d: value %v, want %d", i, v, n)
+				}
+				n++
+			}
+		}
+	}
+	if n != 200 {
+		t.Errorf("sent %d values, want 200", n)
+	}
+}
+
+func TestCollectdName(t *testing.T) {
+	if got := collectdName("a/b\x00c"); got != "a_b_c" {
+		t.Errorf("got %q", got)
+	}
+	long := strings.Repeat("x", collectdNameLen-1) + "é"
+	got := collectdName(long)
+	if len(got) > collectdNameLen || !utf8.ValidString(got) {
+		t.Errorf("truncated to %d bytes, valid %v: %q", len(got), utf8.ValidString(got), got)
+	}
+	if got != strings.Repeat("x", collectdNameLen-1) {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,906 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every HekaClient setting as plain data, for services that load
+// exporter settings from their own configuration
+//
+// zero values select the package defaults
+type Config struct {
+	// Connect is the Heka address, e.g. 'tcp://127.0.0.1:5564'
+	Connect string `json:"connect" toml:"connect"`
+	// Sinks are connect strings of extra outputs sent every message too,
+	// see WithSink
+	Sinks []string `json:"sinks" toml:"sinks"`
+	// Partitions send some metrics as messages of their own, see
+	// WithPartition
+	Partitions []Partition `json:"partitions" toml:"partitions"`
+	// Routes send some metrics to other outputs instead, see WithRoute
+	Routes []RouteConfig `json:"routes" toml:"routes"`
+	// Type sets the 'Type' field on each message
+	Type string `json:"type" toml:"type"`
+	// Interval is the flush interval used by LogHeka, defaults to DefaultInterval
+	Interval time.Duration `json:"interval" toml:"interval"`
+	// LogSuppression is the log repeat window, defaults to
+	// DefaultLogSuppression, negative disables suppression
+	LogSuppression time.Duration `json:"log_suppression" toml:"log_suppression"`
+	// WriteTimeout bounds connecting and each send, 0 means no timeout
+	WriteTimeout time.Duration `json:"write_timeout" toml:"write_timeout"`
+	// Severity sets the 'Severity' field, defaults to DefaultSeverity
+	Severity *int32 `json:"severity" toml:"severity"`
+	// Include limits the metrics sent to names matching one of these patterns
+	Include []string `json:"include" toml:"include"`
+	// Exclude drops metrics with names matching one of these patterns
+	Exclude []string `json:"exclude" toml:"exclude"`
+	// Fields are added as static fields to every message
+	Fields map[string]interface{} `json:"fields" toml:"fields"`
+	// Percentiles sent for histograms and timers, defaults to DefaultPercentiles
+	Percentiles []float64 `json:"percentiles" toml:"percentiles"`
+	// SampleRates send some metrics only with every nth flush, see
+	// WithSampleRate
+	SampleRates []SampleRate `json:"sample_rates" toml:"sample_rates"`
+	// PercentileOverrides set other percentiles for some metrics, see
+	// WithPercentileOverride
+	PercentileOverrides []PercentileOverride `json:"percentile_overrides" toml:"percentile_overrides"`
+	// EagerConnect dials Heka while creating the client, see WithEagerConnect
+	EagerConnect bool `json:"eager_connect" toml:"eager_connect"`
+	// Hostname overrides the 'Hostname' field, defaults to os.Hostname()
+	Hostname string `json:"hostname" toml:"hostname"`
+	// Pid overrides the 'Pid' header, defaults to os.Getpid(), see WithPid
+	Pid *int32 `json:"pid" toml:"pid"`
+	// OmitPid leaves the 'Pid' header unset, see WithoutPid
+	OmitPid bool `json:"omit_pid" toml:"omit_pid"`
+	// EnvVersion sets the 'EnvVersion' header, defaults to the
+	// HEKAMETRICS_ENV_VERSION environment variable, see WithEnvVersion
+	EnvVersion string `json:"env_version" toml:"env_version"`
+	// Logger overrides the 'Logger' header, defaults to DefaultLogger
+	Logger string `json:"logger" toml:"logger"`
+	// SignerName, SignerKey, SignerKeyVersion and SignerHash sign Heka
+	// protobuf messages, see Signer
+	SignerName       string `json:"signer_name" toml:"signer_name"`
+	SignerKey        string `json:"signer_key" toml:"signer_key"`
+	SignerKeyVersion uint32 `json:"signer_key_version" toml:"signer_key_version"`
+	SignerHash       string `json:"signer_hash" toml:"signer_hash"`
+	// NameUUIDs derives message Uuids from the hostname, Timestamp and a
+	// message count, see WithNameUUIDs
+	NameUUIDs bool `json:"name_uuids" toml:"name_uuids"`
+	// CounterMode is 'total' (the default), 'both' or 'delta', see
+	// WithCounterMode
+	CounterMode string `json:"counter_mode" toml:"counter_mode"`
+	// FailureFile gets the messages of failing flushes once FailureThreshold
+	// flushes in a row failed, 1 if not set, see WithFailureFile
+	FailureFile      string `json:"failure_file" toml:"failure_file"`
+	FailureThreshold int    `json:"failure_threshold" toml:"failure_threshold"`
+	// ClockOffset is added to message timestamps, see WithClockOffset
+	ClockOffset time.Duration `json:"clock_offset" toml:"clock_offset"`
+	// ReconnectMinDelay and ReconnectMaxDelay space out connection attempts,
+	// see WithReconnectDelay, and EagerReconnect makes them in the
+	// background, see WithEagerReconnect
+	ReconnectMinDelay time.Duration `json:"reconnect_min_delay" toml:"reconnect_min_delay"`
+	ReconnectMaxDelay time.Duration `json:"reconnect_max_delay" toml:"reconnect_max_delay"`
+	EagerReconnect    bool          `json:"eager_reconnect" toml:"eager_reconnect"`
+	// Heartbeat sends a heartbeat message with every flush, see
+	// WithHeartbeat
+	Heartbeat bool `json:"heartbeat" toml:"heartbeat"`
+	// UDPFallback sends over udp to UDPFallbackAddr, or the tcp address,
+	// once tcp has been unreachable this long, see WithUDPFallback
+	UDPFallback     time.Duration `json:"udp_fallback" toml:"udp_fallback"`
+	UDPFallbackAddr string        `json:"udp_fallback_addr" toml:"udp_fallback_addr"`
+	// Schema sends the descriptions given to Describe, at most every
+	// SchemaEvery, see WithSchema
+	Schema      bool          `json:"schema" toml:"schema"`
+	SchemaEvery time.Duration `json:"schema_every" toml:"schema_every"`
+	// StaleAfter is how long metrics may stay unchanged before they're stale,
+	// StalePolicy is 'flag' (the default) or 'omit' and StaleUnregister
+	// removes them from their registry, see WithStaleMetrics
+	StaleAfter      time.Duration `json:"stale_after" toml:"stale_after"`
+	StalePolicy     string        `json:"stale_policy" toml:"stale_policy"`
+	StaleUnregister bool          `json:"stale_unregister" toml:"stale_unregister"`
+	// TopN limits the counters and timers sent to the largest TopN of each,
+	// plus those matching TopNAlways, see WithTopN
+	TopN       int      `json:"top_n" toml:"top_n"`
+	TopNAlways []string `json:"top_n_always" toml:"top_n_always"`
+	// MeterDeltas adds the increase of each meter's count, see
+	// WithMeterDeltas
+	MeterDeltas bool `json:"meter_deltas" toml:"meter_deltas"`
+	// GaugeRates are patterns of gauges sent with deltas and rates, see
+	// WithGaugeRates
+	GaugeRates []string `json:"gauge_rates" toml:"gauge_rates"`
+	// GaugeSampling reads gauges this often between flushes, see
+	// WithGaugeSampling
+	GaugeSampling time.Duration `json:"gauge_sampling" toml:"gauge_sampling"`
+	// MultiValueFields sends a field of values per histogram, meter and
+	// timer, see WithMultiValueFields
+	MultiValueFields bool `json:"multi_value_fields" toml:"multi_value_fields"`
+	// HybridPayload sends the metrics as JSON in the payload, keeping the
+	// fields matching HybridFields, see WithHybridPayload
+	HybridPayload bool     `json:"hybrid_payload" toml:"hybrid_payload"`
+	HybridFields  []string `json:"hybrid_fields" toml:"hybrid_fields"`
+	// TreePayload nests the JSON payload by the parts of the names, keeping
+	// the fields matching HybridFields, see WithTreePayload
+	TreePayload bool `json:"tree_payload" toml:"tree_payload"`
+	// Sequence numbers each flush in a field, see WithSequence
+	Sequence bool `json:"sequence" toml:"sequence"`
+	// CaptureField adds the time the registries were read in a field, see
+	// WithCaptureField
+	CaptureField bool `json:"capture_field" toml:"capture_field"`
+	// Statmetric sends messages in Heka's statmetric format, see WithStatmetric
+	Statmetric bool `json:"statmetric" toml:"statmetric"`
+	// RateLimit caps outbound bytes per second, 0 means unlimited
+	RateLimit int `json:"rate_limit" toml:"rate_limit"`
+	// ParallelBuild shards building large messages across this many workers
+	ParallelBuild int `json:"parallel_build" toml:"parallel_build"`
+	// NaNPolicy is 'send' (the default), 'skip' or 'zero', see WithNaNPolicy
+	NaNPolicy string `json:"nan_policy" toml:"nan_policy"`
+	// Timestamp is 'end' (the default) or 'start', see WithTimestamp
+	Timestamp string `json:"timestamp" toml:"timestamp"`
+	// OnEncodeError is 'skip' (the default), 'retry' or 'handler', see
+	// WithEncodeErrorPolicy
+	OnEncodeError string `json:"on_encode_error" toml:"on_encode_error"`
+	// MaxMessageSize limits encoded Heka messages, DefaultMaxMessageSize if
+	// nil, 0 disables the limit
+	MaxMessageSize *int `json:"max_message_size" toml:"max_message_size"`
+	// OnOversize is 'split' (the default), 'truncate' or 'error', see
+	// WithOversizePolicy
+	OnOversize string `json:"on_oversize" toml:"on_oversize"`
+	// OnDuplicate is 'suffix' (the default), 'skip', 'error' or 'allow', see
+	// WithDuplicatePolicy
+	OnDuplicate string `json:"on_duplicate" toml:"on_duplicate"`
+	// LargeInts is 'send' (the default), 'double' or 'string', see
+	// WithLargeIntPolicy
+	LargeInts string `json:"large_ints" toml:"large_ints"`
+	// NameScheme is 'dotted' (the default) or 'underscore', see
+	// WithNameScheme
+	NameScheme string `json:"name_scheme" toml:"name_scheme"`
+	// FloatDecimals rounds float values to as many decimal places, see
+	// WithFloatDecimals, nil leaves them alone
+	FloatDecimals *int `json:"float_decimals" toml:"float_decimals"`
+	// FloatDigits rounds float values to as many significant digits instead,
+	// see WithSignificantDigits, 0 leaves them alone
+	FloatDigits int `json:"float_digits" toml:"float_digits"`
+	// MaxNameLength shortens longer field names, see WithMaxNameLength, 0
+	// leaves them alone
+	MaxNameLength int `json:"max_name_length" toml:"max_name_length"`
+	// InvalidNames is 'send' (the default), 'replace', 'escape' or 'skip',
+	// see WithInvalidNamePolicy
+	InvalidNames string `json:"invalid_names" toml:"invalid_names"`
+	// FieldTypes maps metric kinds to 'native', 'integer' or 'double', see
+	// ParseFieldTypePolicy
+	FieldTypes map[string]string `json:"field_types" toml:"field_types"`
+	// FileMaxSize is the size in bytes at which 'file' outputs are rotated,
+	// DefaultFileMaxSize if nil, 0 never rotates
+	FileMaxSize *int64 `json:"file_max_size" toml:"file_max_size"`
+	// FileKeep is how many rotated files are kept, DefaultFileKeep if nil
+	FileKeep *int `json:"file_keep" toml:"file_keep"`
+	// AMQPExchange and AMQPRoutingKey are where 'amqp' outputs publish, see
+	// WithAMQP
+	AMQPExchange   string `json:"amqp_exchange" toml:"amqp_exchange"`
+	AMQPRoutingKey string `json:"amqp_routing_key" toml:"amqp_routing_key"`
+	// NATSSubject is where 'nats' outputs publish, see WithNATS
+	NATSSubject string `json:"nats_subject" toml:"nats_subject"`
+	// RedisKey and RedisMode are where and how 'redis' outputs add
+	// messages, see WithRedis
+	RedisKey  string `json:"redis_key" toml:"redis_key"`
+	RedisMode string `json:"redis_mode" toml:"redis_mode"`
+	// FluentTag is the tag of events sent by 'forward' outputs, see
+	// WithFluentTag
+	FluentTag string `json:"fluent_tag" toml:"fluent_tag"`
+}
+
+// PercentileOverride is the percentiles for the histograms and timers
+// matching Pattern, written in a TOML config as
+//
+//	[[percentile_overrides]]
+//	pattern = "api.*"
+//	percentiles = [0.5, 0.99, 0.999]
+type PercentileOverride struct {
+	Pattern     string    `json:"pattern" toml:"pattern"`
+	Percentiles []float64 `json:"percentiles" toml:"percentiles"`
+}
+
+// SampleRate has the metrics matching Pattern sent with every Every flush,
+// written in a TOML config as
+//
+//	[[sample_rates]]
+//	pattern = "cache.*"
+//	every = 10
+type SampleRate struct {
+	Pattern string `json:"pattern" toml:"pattern"`
+	Every   int    `json:"every" toml:"every"`
+}
+
+// RouteConfig is a Route and the connect string of the output it sends to,
+// written in a TOML config as
+//
+//	[[routes]]
+//	connect = "udp://127.0.0.1:5565"
+//	kinds = ["timers"]
+type RouteConfig struct {
+	Connect string   `json:"connect" toml:"connect"`
+	Names   []string `json:"names" toml:"names"`
+	Kinds   []string `json:"kinds" toml:"kinds"`
+}
+
+// Validate checks c for mistakes and returns a descriptive error for the first
+// one found
+func (c *Config) Validate() error {
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("config: %s", err)
+	}
+	return nil
+}
+
+// validate is Validate without the 'config: ' prefix on errors
+func (c *Config) validate() error {
+	if c.Connect == "" {
+		return errors.New("connect: required")
+	}
+	u, err := parseConnect(c.Connect)
+	if err != nil {
+		return fmt.Errorf("connect: %s", err)
+	}
+	if _, err = checkQuery(u); err != nil {
+		return fmt.Errorf("connect: %s", err)
+	}
+	for _, connect := range c.Sinks {
+		u, err := parseConnect(connect)
+		if err != nil {
+			return fmt.Errorf("sinks: %s", err)
+		}
+		if _, err = checkQuery(u); err != nil {
+			return fmt.Errorf("sinks: %s", err)
+		}
+	}
+	for i, p := range c.Partitions {
+		if _, err = newPartition(p); err != nil {
+			return fmt.Errorf("partitions[%d]: %s", i, err)
+		}
+	}
+	for i, rc := range c.Routes {
+		u, err := parseConnect(rc.Connect)
+		if err != nil {
+			return fmt.Errorf("routes[%d]: %s", i, err)
+		}
+		if _, err = checkQuery(u); err != nil {
+			return fmt.Errorf("routes[%d]: %s", i, err)
+		}
+		r := Route{rc.Names, rc.Kinds}
+		if err = r.check(); err != nil {
+			return fmt.Errorf("routes[%d]: %s", i, err)
+		}
+	}
+	payload := ""
+	switch {
+	case c.Statmetric:
+		payload = "statmetric"
+	case c.TreePayload:
+		payload = "tree_payload"
+	case c.HybridPayload:
+		payload = "hybrid_payload"
+	}
+	if c.MultiValueFields || payload != "" {
+		connects := append([]string{c.Connect}, c.Sinks...)
+		for _, rc := range c.Routes {
+			connects = append(connects, rc.Connect)
+		}
+		for _, connect := range connects {
+			u, _ := parseConnect(connect)
+			if c.MultiValueFields {
+				if err = checkMultiValues(u); err != nil {
+					return err
+				}
+			}
+			if payload != "" {
+				if err = checkPayload(u, payload); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval: must not be negative, got %s", c.Interval)
+	}
+	if c.WriteTimeout < 0 {
+		return fmt.Errorf("write_timeout: must not be negative, got %s", c.WriteTimeout)
+	}
+	if c.FailureFile != "" && !filepath.IsAbs(c.FailureFile) {
+		return fmt.Errorf("failure_file: must be an absolute path, got '%s'", c.FailureFile)
+	}
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold: must not be negative, got %d", c.FailureThreshold)
+	}
+	if c.ReconnectMinDelay < 0 {
+		return fmt.Errorf("reconnect_min_delay: must not be negative, got %s", c.ReconnectMinDelay)
+	}
+	if c.ReconnectMaxDelay != 0 && c.ReconnectMaxDelay < c.ReconnectMinDelay {
+		return fmt.Errorf("reconnect_max_delay: must not be less than reconnect_min_delay, got %s", c.ReconnectMaxDelay)
+	}
+	if c.UDPFallback < 0 {
+		return fmt.Errorf("udp_fallback: must not be negative, got %s", c.UDPFallback)
+	}
+	if c.UDPFallbackAddr != "" {
+		if _, _, err = net.SplitHostPort(c.UDPFallbackAddr); err != nil {
+			return fmt.Errorf("udp_fallback_addr: %s", err)
+		}
+	}
+	if c.SchemaEvery < 0 {
+		return fmt.Errorf("schema_every: must not be negative, got %s", c.SchemaEvery)
+	}
+	if c.StaleAfter < 0 {
+		return fmt.Errorf("stale_after: must not be negative, got %s", c.StaleAfter)
+	}
+	if c.StalePolicy != "" {
+		if _, err = ParseStalePolicy(c.StalePolicy); err != nil {
+			return fmt.Errorf("stale_policy: %s", err)
+		}
+	}
+	if c.TopN < 0 {
+		return fmt.Errorf("top_n: must not be negative, got %d", c.TopN)
+	}
+	if err = checkPatterns(c.TopNAlways); err != nil {
+		return fmt.Errorf("top_n_always: %s", err)
+	}
+	if c.GaugeSampling < 0 {
+		return fmt.Errorf("gauge_sampling: must not be negative, got %s", c.GaugeSampling)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate_limit: must not be negative, got %d", c.RateLimit)
+	}
+	if c.ParallelBuild < 0 {
+		return fmt.Errorf("parallel_build: must not be negative, got %d", c.ParallelBuild)
+	}
+	if c.NaNPolicy != "" {
+		if _, err = ParseNaNPolicy(c.NaNPolicy); err != nil {
+			return fmt.Errorf("nan_policy: %s", err)
+		}
+	}
+	if c.Timestamp != "" {
+		if _, err = ParseTimestampMode(c.Timestamp); err != nil {
+			return fmt.Errorf("timestamp: %s", err)
+		}
+	}
+	if c.OnEncodeError != "" {
+		if _, err = ParseEncodeErrorPolicy(c.OnEncodeError); err != nil {
+			return fmt.Errorf("on_encode_error: %s", err)
+		}
+	}
+	if c.MaxMessageSize != nil && *c.MaxMessageSize < 0 {
+		return fmt.Errorf("max_message_size: must not be negative, got %d", *c.MaxMessageSize)
+	}
+	if c.OnOversize != "" {
+		if _, err = ParseOversizePolicy(c.OnOversize); err != nil {
+			return fmt.Errorf("on_oversize: %s", err)
+		}
+	}
+	if c.OnDuplicate != "" {
+		if _, err = ParseDuplicatePolicy(c.OnDuplicate); err != nil {
+			return fmt.Errorf("on_duplicate: %s", err)
+		}
+	}
+	if c.CounterMode != "" {
+		if _, err = ParseCounterMode(c.CounterMode); err != nil {
+			return fmt.Errorf("counter_mode: %s", err)
+		}
+	}
+	if c.LargeInts != "" {
+		if _, err = ParseLargeIntPolicy(c.LargeInts); err != nil {
+			return fmt.Errorf("large_ints: %s", err)
+		}
+	}
+	if c.FloatDecimals != nil && *c.FloatDecimals < 0 {
+		return fmt.Errorf("float_decimals: must not be negative, got %d", *c.FloatDecimals)
+	}
+	if c.FloatDigits < 0 {
+		return fmt.Errorf("float_digits: must not be negative, got %d", c.FloatDigits)
+	}
+	if c.FloatDecimals != nil && c.FloatDigits > 0 {
+		return fmt.Errorf("float_decimals and float_digits are exclusive")
+	}
+	if c.MaxNameLength != 0 && c.MaxNameLength < MinNameLength {
+		return fmt.Errorf("max_name_length: must be 0 or at least %d, got %d", MinNameLength, c.MaxNameLength)
+	}
+	if c.InvalidNames != "" {
+		if _, err = ParseInvalidNamePolicy(c.InvalidNames); err != nil {
+			return fmt.Errorf("invalid_names: %s", err)
+		}
+	}
+	if c.NameScheme != "" {
+		if _, err = ParseNameScheme(c.NameScheme); err != nil {
+			return fmt.Errorf("name_scheme: %s", err)
+		}
+	}
+	if _, err = ParseFieldTypePolicy(c.FieldTypes); err != nil {
+		return fmt.Errorf("field_types: %s", err)
+	}
+	if c.FileMaxSize != nil && *c.FileMaxSize < 0 {
+		return fmt.Errorf("file_max_size: must not be negative, got %d", *c.FileMaxSize)
+	}
+	if c.FileKeep != nil && *c.FileKeep < 0 {
+		return fmt.Errorf("file_keep: must not be negative, got %d", *c.FileKeep)
+	}
+	if c.RedisMode != "" && c.RedisMode != RedisList && c.RedisMode != RedisStream {
+		return fmt.Errorf("redis_mode: unknown mode '%s', try '%s' or '%s'", c.RedisMode, RedisList, RedisStream)
+	}
+	if c.SignerName != "" || c.SignerKey != "" {
+		if _, err = newSigning(c.signer()); err != nil {
+			return fmt.Errorf("%s", err)
+		}
+	}
+	if err = checkPatterns(c.GaugeRates); err != nil {
+		return fmt.Errorf("gauge_rates: %s", err)
+	}
+	if err = checkPatterns(c.HybridFields); err != nil {
+		return fmt.Errorf("hybrid_fields: %s", err)
+	}
+	if err = checkPatterns(c.Include); err != nil {
+		return fmt.Errorf("include: %s", err)
+	}
+	if err = checkPatterns(c.Exclude); err != nil {
+		return fmt.Errorf("exclude: %s", err)
+	}
+	if err = checkPercentiles(c.Percentiles); err != nil {
+		return fmt.Errorf("percentiles: %s", err)
+	}
+	for _, o := range c.PercentileOverrides {
+		if err = checkPatterns([]string{o.Pattern}); err != nil {
+			return fmt.Errorf("percentile_overrides: %s", err)
+		}
+		if err = checkPercentiles(o.Percentiles); err != nil {
+			return fmt.Errorf("percentile_overrides: %s: %s", o.Pattern, err)
+		}
+	}
+	for _, r := range c.SampleRates {
+		if err = checkPatterns([]string{r.Pattern}); err != nil {
+			return fmt.Errorf("sample_rates: %s", err)
+		}
+		if r.Every < 1 {
+			return fmt.Errorf("sample_rates: %s: every must be positive, got %d", r.Pattern, r.Every)
+		}
+	}
+	for name, value := range c.Fields {
+		if _, err = message.NewField(name, value, ""); err != nil {
+			return fmt.Errorf("fields: %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// signer returns the Signer set in c
+func (c *Config) signer() Signer {
+	return Signer{Name: c.SignerName, Key: c.SignerKey, KeyVersion: c.SignerKeyVersion, Hash: c.SignerHash}
+}
+
+// options translates the non-zero settings of c into Options
+func (c *Config) options() []Option {
+	var opts []Option
+	if c.Interval > 0 {
+		opts = append(opts, WithInterval(c.Interval))
+	}
+	if c.LogSuppression != 0 {
+		opts = append(opts, WithLogSuppression(c.LogSuppression))
+	}
+	if c.WriteTimeout > 0 {
+		opts = append(opts, WithWriteTimeout(c.WriteTimeout))
+	}
+	if c.Severity != nil {
+		opts = append(opts, WithSeverity(*c.Severity))
+	}
+	if len(c.Include) > 0 {
+		opts = append(opts, WithInclude(c.Include...))
+	}
+	if len(c.Exclude) > 0 {
+		opts = append(opts, WithExclude(c.Exclude...))
+	}
+	if len(c.Percentiles) > 0 {
+		opts = append(opts, WithPercentiles(c.Percentiles...))
+	}
+	for _, r := range c.SampleRates {
+		opts = append(opts, WithSampleRate(r.Pattern, r.Every))
+	}
+	for _, o := range c.PercentileOverrides {
+		opts = append(opts, WithPercentileOverride(o.Pattern, o.Percentiles...))
+	}
+	if c.Hostname != "" {
+		opts = append(opts, WithHostname(c.Hostname))
+	}
+	if c.Pid != nil {
+		opts = append(opts, WithPid(*c.Pid))
+	}
+	if c.OmitPid {
+		opts = append(opts, WithoutPid())
+	}
+	if c.EnvVersion != "" {
+		opts = append(opts, WithEnvVersion(c.EnvVersion))
+	}
+	if c.Logger != "" {
+		opts = append(opts, WithLogger(c.Logger))
+	}
+	if c.RateLimit > 0 {
+		opts = append(opts, WithRateLimit(c.RateLimit))
+	}
+	if c.ParallelBuild > 0 {
+		opts = append(opts, WithParallelBuild(c.ParallelBuild))
+	}
+	if p, err := ParseNaNPolicy(c.NaNPolicy); err == nil {
+		opts = append(opts, WithNaNPolicy(p))
+	}
+	if m, err := ParseTimestampMode(c.Timestamp); err == nil {
+		opts = append(opts, WithTimestamp(m))
+	}
+	if p, err := ParseEncodeErrorPolicy(c.OnEncodeError); err == nil {
+		opts = append(opts, WithEncodeErrorPolicy(p))
+	}
+	if c.MaxMessageSize != nil {
+		opts = append(opts, WithMaxMessageSize(*c.MaxMessageSize))
+	}
+	if p, err := ParseOversizePolicy(c.OnOversize); err == nil {
+		opts = append(opts, WithOversizePolicy(p))
+	}
+	if p, err := ParseDuplicatePolicy(c.OnDuplicate); err == nil {
+		opts = append(opts, WithDuplicatePolicy(p))
+	}
+	if p, err := ParseLargeIntPolicy(c.LargeInts); err == nil {
+		opts = append(opts, WithLargeIntPolicy(p))
+	}
+	if n, err := ParseNameScheme(c.NameScheme); err == nil {
+		opts = append(opts, WithNameScheme(n))
+	}
+	if p, err := ParseInvalidNamePolicy(c.InvalidNames); err == nil {
+		opts = append(opts, WithInvalidNamePolicy(p))
+	}
+	if c.MaxNameLength > 0 {
+		opts = append(opts, WithMaxNameLength(c.MaxNameLength))
+	}
+	if c.FloatDecimals != nil {
+		opts = append(opts, WithFloatDecimals(*c.FloatDecimals))
+	}
+	if c.FloatDigits > 0 {
+		opts = append(opts, WithSignificantDigits(c.FloatDigits))
+	}
+	if p, err := ParseFieldTypePolicy(c.FieldTypes); err == nil && len(c.FieldTypes) > 0 {
+		opts = append(opts, WithFieldTypes(p))
+	}
+	if c.FileMaxSize != nil || c.FileKeep != nil {
+		maxSize, keep := int64(DefaultFileMaxSize), DefaultFileKeep
+		if c.FileMaxSize != nil {
+			maxSize = *c.FileMaxSize
+		}
+		if c.FileKeep != nil {
+			keep = *c.FileKeep
+		}
+		opts = append(opts, WithFileRotation(maxSize, keep))
+	}
+	if c.AMQPExchange != "" || c.AMQPRoutingKey != "" {
+		exchange := c.AMQPExchange
+		if exchange == "" {
+			exchange = DefaultAMQPExchange
+		}
+		opts = append(opts, WithAMQP(exchange, c.AMQPRoutingKey))
+	}
+	if c.NATSSubject != "" {
+		opts = append(opts, WithNATS(c.NATSSubject))
+	}
+	if c.RedisKey != "" || c.RedisMode != "" {
+		key, mode := c.RedisKey, c.RedisMode
+		if key == "" {
+			key = DefaultRedisKey
+		}
+		if mode == "" {
+			mode = RedisList
+		}
+		opts = append(opts, WithRedis(key, mode))
+	}
+	for _, connect := range c.Sinks {
+		opts = append(opts, WithSink(connect))
+	}
+	for _, p := range c.Partitions {
+		opts = append(opts, WithPartition(p))
+	}
+	for _, rc := range c.Routes {
+		opts = append(opts, WithRoute(Route{rc.Names, rc.Kinds}, rc.Connect))
+	}
+	if c.FluentTag != "" {
+		opts = append(opts, WithFluentTag(c.FluentTag))
+	}
+	if c.SignerName != "" || c.SignerKey != "" {
+		opts = append(opts, WithSigner(c.signer()))
+	}
+	if m, err := ParseCounterMode(c.CounterMode); err == nil {
+		opts = append(opts, WithCounterMode(m))
+	}
+	if c.FailureFile != "" {
+		n := c.FailureThreshold
+		if n == 0 {
+			n = 1
+		}
+		opts = append(opts, WithFailureFile(c.FailureFile, n))
+	}
+	if c.ClockOffset != 0 {
+		opts = append(opts, WithClockOffset(c.ClockOffset))
+	}
+	if c.ReconnectMinDelay > 0 {
+		opts = append(opts, WithReconnectDelay(c.ReconnectMinDelay, c.ReconnectMaxDelay))
+	}
+	if c.EagerReconnect {
+		opts = append(opts, WithEagerReconnect())
+	}
+	if c.Heartbeat {
+		opts = append(opts, WithHeartbeat())
+	}
+	if c.UDPFallback > 0 {
+		opts = append(opts, WithUDPFallback(c.UDPFallback, c.UDPFallbackAddr))
+	}
+	if c.Schema {
+		opts = append(opts, WithSchema(c.SchemaEvery))
+	}
+	if c.StaleAfter > 0 {
+		// an empty policy parses as StaleFlag, the zero value
+		p, _ := ParseStalePolicy(c.StalePolicy)
+		opts = append(opts, WithStaleMetrics(c.StaleAfter, p, c.StaleUnregister))
+	}
+	if c.TopN > 0 {
+		opts = append(opts, WithTopN(c.TopN, c.TopNAlways...))
+	}
+	if c.MeterDeltas {
+		opts = append(opts, WithMeterDeltas())
+	}
+	if len(c.GaugeRates) > 0 {
+		opts = append(opts, WithGaugeRates(c.GaugeRates...))
+	}
+	if c.GaugeSampling > 0 {
+		opts = append(opts, WithGaugeSampling(c.GaugeSampling))
+	}
+	if c.MultiValueFields {
+		opts = append(opts, WithMultiValueFields())
+	}
+	if c.TreePayload {
+		opts = append(opts, WithTreePayload(c.HybridFields...))
+	} else if c.HybridPayload {
+		opts = append(opts, WithHybridPayload(c.HybridFields...))
+	}
+	if c.NameUUIDs {
+		opts = append(opts, WithNameUUIDs())
+	}
+	if c.Sequence {
+		opts = append(opts, WithSequence())
+	}
+	if c.CaptureField {
+		opts = append(opts, WithCaptureField())
+	}
+	if c.Statmetric {
+		opts = append(opts, WithStatmetric())
+	}
+	if c.EagerConnect {
+		opts = append(opts, WithEagerConnect())
+	}
+	names := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts = append(opts, WithField(name, c.Fields[name]))
+	}
+	return opts
+}
+
+// Set assigns the setting named key, as spelled in the Config struct tags, from
+// its string form
+//
+// durations use time.ParseDuration syntax and lists are comma separated
+//
+// maps are written as comma separated 'key=value' pairs
+func (c *Config) Set(key, value string) error {
+	f, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("%s: unknown setting", key)
+	}
+	if err := setValue(f, value); err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	return nil
+}
+
+// field returns the settable struct field tagged key
+func (c *Config) field(key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(f reflect.Value, value string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := setValue(p.Elem(), value); err != nil {
+			return err
+		}
+		f.Set(p)
+	case reflect.Map:
+		m := reflect.MakeMap(f.Type())
+		for _, pair := range strings.Split(value, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("'%s' is not a key=value pair", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])),
+				reflect.ValueOf(strings.TrimSpace(kv[1])))
+		}
+		f.Set(m)
+	case reflect.Slice:
+		parts := strings.Split(value, ",")
+		s := reflect.MakeSlice(f.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(s.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+	default:
+		return fmt.Errorf("can't be set from a string")
+	}
+	return nil
+}
+
+// queryConfig reads the settings embedded as query parameters in a connect URL
+func queryConfig(u *url.URL) (*Config, error) {
+	c := &Config{}
+	for key, values := range u.Query() {
+		if key == "connect" {
+			return nil, fmt.Errorf("%s: can't be set in the connect string", key)
+		}
+		for _, value := range values {
+			if err := c.Set(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// checkQuery reads and validates the settings embedded as query parameters
+// in a connect URL, so a bad value in a connect string fails like it does in
+// any other Config
+func checkQuery(u *url.URL) (*Config, error) {
+	c, err := queryConfig(u)
+	if err != nil || u.RawQuery == "" {
+		return c, err
+	}
+	bare := *u
+	bare.RawQuery = ""
+	c.Connect = bare.String()
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewFromConfig validates cfg and creates a HekaClient from it
+//
+// opts are applied after the settings from cfg
+func NewFromConfig(cfg *Config, opts ...Option) (*HekaClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return NewHekaClient(cfg.Connect, cfg.Type, append(cfg.options(), opts...)...)
+}
+
+// EnvPrefix starts the names of the environment variables read by
+// ConfigFromEnv
+const EnvPrefix = "HEKAMETRICS_"
+
+// ConfigFromEnv builds a Config from environment variables named EnvPrefix
+// followed by the upper cased setting, e.g. HEKAMETRICS_CONNECT,
+// HEKAMETRICS_TYPE and HEKAMETRICS_INTERVAL
+//
+// unset variables leave the setting at its default
+func ConfigFromEnv() (*Config, error) {
+	c := &Config{}
+	t := reflect.TypeOf(c).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("toml")
+		name := EnvPrefix + strings.ToUpper(key)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return nil, fmt.Errorf("env: %s: %s", name, err)
+		}
+	}
+	return c, nil
+}
+
+// NewHekaClientFromEnv creates a HekaClient configured by ConfigFromEnv
+//
+// opts are applied after the settings from the environment
+func NewHekaClientFromEnv(opts ...Option) (*HekaClient, error) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConfig(c, opts...)
+}
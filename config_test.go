@@ -0,0 +1,497 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHekaClientBadQuery(t *testing.T) {
+	for _, query := range []string{
+		"nan_policy=bogus",
+		"counter_mode=x",
+		"on_oversize=x",
+		"interval=-1s",
+		"write_timeout=-1s",
+		"rate_limit=-5",
+		"stale_policy=flagg",
+		"large_ints=huge",
+		"percentiles=1.5",
+		"nosuch=1",
+		"connect=tcp://elsewhere:5565",
+	} {
+		if _, err := NewHekaClient("tcp://127.0.0.1:5565?"+query, "stats"); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+		cfg := &Config{Connect: "tcp://127.0.0.1:5565?" + query}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate: expected an error", query)
+		} else if !strings.HasPrefix(err.Error(), "config: connect: ") {
+			t.Errorf("%s: Validate: unexpected error '%s'", query, err)
+		}
+	}
+}
+
+func TestNewHekaClientQuery(t *testing.T) {
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565?nan_policy=skip&interval=10s&type=metrics", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc.build.nan != NaNSkip || hc.interval.String() != "10s" || hc.msgtype != "metrics" {
+		t.Errorf("query settings not applied: %v %s %s", hc.build.nan, hc.interval, hc.msgtype)
+	}
+	if hc.connect_s.RawQuery != "" {
+		t.Errorf("query left on the connect URL: %s", hc.connect_s)
+	}
+}
+
+// validSettings holds a valid string form of every setting Set accepts
+var validSettings = map[string]string{
+	"connect":             "tcp://127.0.0.1:5565",
+	"sinks":               "udp://127.0.0.1:5565, file:///var/lib/metrics/stats.pb",
+	"type":                "metrics",
+	"interval":            "10s",
+	"log_suppression":     "-1s",
+	"write_timeout":       "2s",
+	"severity":            "4",
+	"include":             "api.*, db.*",
+	"exclude":             "debug.*",
+	"fields":              "environment=production, region=us-east",
+	"percentiles":         "0.5, 0.99",
+	"eager_connect":       "false",
+	"hostname":            "web1",
+	"pid":                 "42",
+	"omit_pid":            "true",
+	"env_version":         "0.8",
+	"logger":              "myapp",
+	"signer_name":         "myapp",
+	"signer_key":          "secret",
+	"signer_key_version":  "2",
+	"signer_hash":         "sha1",
+	"name_uuids":          "true",
+	"counter_mode":        "both",
+	"failure_file":        "/var/lib/metrics/failed.pb",
+	"failure_threshold":   "3",
+	"clock_offset":        "-250ms",
+	"reconnect_min_delay": "1s",
+	"reconnect_max_delay": "1m",
+	"eager_reconnect":     "true",
+	"heartbeat":           "true",
+	"udp_fallback":        "30s",
+	"udp_fallback_addr":   "127.0.0.1:5566",
+	"schema":              "true",
+	"schema_every":        "1h",
+	"stale_after":         "5m",
+	"stale_policy":        "omit",
+	"stale_unregister":    "true",
+	"top_n":               "10",
+	"top_n_always":        "api.errors",
+	"meter_deltas":        "true",
+	"gauge_rates":         "queue.*",
+	"gauge_sampling":      "1s",
+	"multi_value_fields":  "true",
+	"hybrid_payload":      "true",
+	"hybrid_fields":       "api.*",
+	"tree_payload":        "true",
+	"sequence":            "true",
+	"capture_field":       "true",
+	"statmetric":          "true",
+	"rate_limit":          "65536",
+	"parallel_build":      "4",
+	"nan_policy":          "zero",
+	"timestamp":           "start",
+	"on_encode_error":     "retry",
+	"max_message_size":    "0",
+	"on_oversize":         "truncate",
+	"on_duplicate":        "skip",
+	"large_ints":          "double",
+	"name_scheme":         "underscore",
+	"float_decimals":      "2",
+	"float_digits":        "0",
+	"max_name_length":     "64",
+	"invalid_names":       "replace",
+	"field_types":         "all=double, counters=integer",
+	"file_max_size":       "1048576",
+	"file_keep":           "3",
+	"amqp_exchange":       "metrics",
+	"amqp_routing_key":    "heka",
+	"nats_subject":        "metrics.heka",
+	"redis_key":           "metrics",
+	"redis_mode":          "stream",
+	"fluent_tag":          "metrics.heka",
+}
+
+// structSettings are the settings only set from JSON or TOML documents
+var structSettings = map[string]bool{
+	"partitions":           true,
+	"routes":               true,
+	"sample_rates":         true,
+	"percentile_overrides": true,
+}
+
+// configKeys returns the keys of every setting, as spelled in the tags
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("toml"))
+	}
+	return keys
+}
+
+func TestConfigSetEveryKey(t *testing.T) {
+	for _, key := range configKeys() {
+		c := &Config{Connect: "tcp://127.0.0.1:5565"}
+		if structSettings[key] {
+			if err := c.Set(key, "x"); err == nil || !strings.Contains(err.Error(), "can't be set from a string") {
+				t.Errorf("%s: expected a 'can't be set' error, got %v", key, err)
+			}
+			continue
+		}
+		value, ok := validSettings[key]
+		if !ok {
+			t.Errorf("%s: no valid value in the test table", key)
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			t.Errorf("%s=%s: %s", key, value, err)
+			continue
+		}
+		if strings.HasPrefix(key, "signer_") {
+			c.SignerName, c.SignerKey = "myapp", "secret"
+		}
+		if err := c.Validate(); err != nil {
+			t.Errorf("%s=%s: Validate: %s", key, value, err)
+		}
+	}
+}
+
+func TestConfigSetAll(t *testing.T) {
+	c := &Config{}
+	for key, value := range validSettings {
+		if err := c.Set(key, value); err != nil {
+			t.Fatalf("%s=%s: %s", key, value, err)
+		}
+	}
+	if c.Interval != 10*time.Second || c.LogSuppression != -time.Second || c.ClockOffset != -250*time.Millisecond {
+		t.Errorf("durations: %s %s %s", c.Interval, c.LogSuppression, c.ClockOffset)
+	}
+	if c.Severity == nil || *c.Severity != 4 || c.Pid == nil || *c.Pid != 42 {
+		t.Errorf("pointers: %v %v", c.Severity, c.Pid)
+	}
+	if c.MaxMessageSize == nil || *c.MaxMessageSize != 0 || c.FileMaxSize == nil || *c.FileMaxSize != 1048576 {
+		t.Errorf("sizes: %v %v", c.MaxMessageSize, c.FileMaxSize)
+	}
+	if !reflect.DeepEqual(c.Sinks, []string{"udp://127.0.0.1:5565", "file:///var/lib/metrics/stats.pb"}) {
+		t.Errorf("sinks: %q", c.Sinks)
+	}
+	if !reflect.DeepEqual(c.Percentiles, []float64{0.5, 0.99}) {
+		t.Errorf("percentiles: %v", c.Percentiles)
+	}
+	if !reflect.DeepEqual(c.Fields, map[string]interface{}{"environment": "production", "region": "us-east"}) {
+		t.Errorf("fields: %v", c.Fields)
+	}
+	if !reflect.DeepEqual(c.FieldTypes, map[string]string{"all": "double", "counters": "integer"}) {
+		t.Errorf("field_types: %v", c.FieldTypes)
+	}
+	if !c.OmitPid || c.SignerKeyVersion != 2 || c.RateLimit != 65536 || c.FloatDecimals == nil || *c.FloatDecimals != 2 {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate: %s", err)
+	}
+}
+
+func TestConfigSetErrors(t *testing.T) {
+	for _, test := range []struct {
+		key, value string
+	}{
+		{"nosuch", "1"},
+		{"Interval", "10s"},
+		{"interval", "10"},
+		{"interval", "soon"},
+		{"write_timeout", "1x"},
+		{"eager_connect", "maybe"},
+		{"severity", "high"},
+		{"severity", "4294967296"},
+		{"pid", "-x"},
+		{"signer_key_version", "-1"},
+		{"rate_limit", "1.5"},
+		{"top_n", "ten"},
+		{"percentiles", "0.5, high"},
+		{"fields", "environment"},
+		{"field_types", "all=double, counters"},
+		{"max_message_size", "big"},
+		{"file_max_size", "1MB"},
+		{"float_decimals", "two"},
+	} {
+		c := &Config{}
+		err := c.Set(test.key, test.value)
+		if err == nil {
+			t.Errorf("%s=%s: expected an error", test.key, test.value)
+		} else if !strings.HasPrefix(err.Error(), test.key+": ") {
+			t.Errorf("%s=%s: unexpected error '%s'", test.key, test.value, err)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	neg := -1
+	neg64 := int64(-1)
+	zero := 0
+	for _, test := range []struct {
+		name string
+		set  func(c *Config)
+		err  string
+	}{
+		{"no connect", func(c *Config) { c.Connect = "" }, "connect: required"},
+		{"bad scheme", func(c *Config) { c.Connect = "ftp://127.0.0.1:5565" }, "connect: "},
+		{"no host", func(c *Config) { c.Connect = "tcp://" }, "connect: "},
+		{"no path", func(c *Config) { c.Connect = "file://" }, "connect: "},
+		{"bad query", func(c *Config) { c.Connect += "?interval=soon" }, "connect: interval: "},
+		{"invalid query", func(c *Config) { c.Connect += "?rate_limit=-1" }, "connect: rate_limit: "},
+		{"query connect", func(c *Config) { c.Connect += "?connect=udp://127.0.0.1:5565" }, "connect: connect: can't be set"},
+		{"bad sink", func(c *Config) { c.Sinks = []string{"ftp://127.0.0.1:5565"} }, "sinks: "},
+		{"bad sink query", func(c *Config) { c.Sinks = []string{"udp://127.0.0.1:5565?nan_policy=x"} }, "sinks: nan_policy: "},
+		{"empty partition", func(c *Config) { c.Partitions = []Partition{{}} }, "partitions[0]: names: "},
+		{"bad partition pattern", func(c *Config) { c.Partitions = []Partition{{Names: []string{"["}}} }, "partitions[0]: names: "},
+		{"bad partition field", func(c *Config) {
+			c.Partitions = []Partition{{Names: []string{"api.*"}, Fields: map[string]interface{}{"x": struct{}{}}}}
+		}, "partitions[0]: fields: x: "},
+		{"bad route connect", func(c *Config) { c.Routes = []RouteConfig{{Connect: "ftp://x:1"}} }, "routes[0]: "},
+		{"bad route query", func(c *Config) { c.Routes = []RouteConfig{{Connect: "udp://127.0.0.1:5565?top_n=-1"}} }, "routes[0]: top_n: "},
+		{"bad route pattern", func(c *Config) {
+			c.Routes = []RouteConfig{{Connect: "udp://127.0.0.1:5565", Names: []string{"["}}}
+		}, "routes[0]: names: "},
+		{"bad route kind", func(c *Config) {
+			c.Routes = []RouteConfig{{Connect: "udp://127.0.0.1:5565", Kinds: []string{"sets"}}}
+		}, "routes[0]: kinds: "},
+		{"negative interval", func(c *Config) { c.Interval = -time.Second }, "interval: "},
+		{"negative write_timeout", func(c *Config) { c.WriteTimeout = -time.Second }, "write_timeout: "},
+		{"relative failure_file", func(c *Config) { c.FailureFile = "failed.pb" }, "failure_file: "},
+		{"negative failure_threshold", func(c *Config) { c.FailureThreshold = -1 }, "failure_threshold: "},
+		{"negative reconnect_min_delay", func(c *Config) { c.ReconnectMinDelay = -time.Second }, "reconnect_min_delay: "},
+		{"reconnect_max_delay below min", func(c *Config) {
+			c.ReconnectMinDelay, c.ReconnectMaxDelay = time.Minute, time.Second
+		}, "reconnect_max_delay: "},
+		{"negative udp_fallback", func(c *Config) { c.UDPFallback = -time.Second }, "udp_fallback: "},
+		{"bad udp_fallback_addr", func(c *Config) { c.UDPFallbackAddr = "127.0.0.1" }, "udp_fallback_addr: "},
+		{"negative schema_every", func(c *Config) { c.SchemaEvery = -time.Second }, "schema_every: "},
+		{"negative stale_after", func(c *Config) { c.StaleAfter = -time.Second }, "stale_after: "},
+		{"bad stale_policy", func(c *Config) { c.StalePolicy = "flagg" }, "stale_policy: "},
+		{"negative top_n", func(c *Config) { c.TopN = -1 }, "top_n: "},
+		{"bad top_n_always", func(c *Config) { c.TopNAlways = []string{"["} }, "top_n_always: "},
+		{"negative gauge_sampling", func(c *Config) { c.GaugeSampling = -time.Second }, "gauge_sampling: "},
+		{"negative rate_limit", func(c *Config) { c.RateLimit = -1 }, "rate_limit: "},
+		{"negative parallel_build", func(c *Config) { c.ParallelBuild = -1 }, "parallel_build: "},
+		{"bad nan_policy", func(c *Config) { c.NaNPolicy = "drop" }, "nan_policy: "},
+		{"bad timestamp", func(c *Config) { c.Timestamp = "middle" }, "timestamp: "},
+		{"bad on_encode_error", func(c *Config) { c.OnEncodeError = "panic" }, "on_encode_error: "},
+		{"negative max_message_size", func(c *Config) { c.MaxMessageSize = &neg }, "max_message_size: "},
+		{"bad on_oversize", func(c *Config) { c.OnOversize = "drop" }, "on_oversize: "},
+		{"bad on_duplicate", func(c *Config) { c.OnDuplicate = "merge" }, "on_duplicate: "},
+		{"bad counter_mode", func(c *Config) { c.CounterMode = "rate" }, "counter_mode: "},
+		{"bad large_ints", func(c *Config) { c.LargeInts = "huge" }, "large_ints: "},
+		{"negative float_decimals", func(c *Config) { c.FloatDecimals = &neg }, "float_decimals: "},
+		{"negative float_digits", func(c *Config) { c.FloatDigits = -1 }, "float_digits: "},
+		{"float_decimals and float_digits", func(c *Config) { c.FloatDecimals, c.FloatDigits = &zero, 3 }, "float_decimals and float_digits"},
+		{"short max_name_length", func(c *Config) { c.MaxNameLength = MinNameLength - 1 }, "max_name_length: "},
+		{"bad invalid_names", func(c *Config) { c.InvalidNames = "drop" }, "invalid_names: "},
+		{"bad name_scheme", func(c *Config) { c.NameScheme = "camel" }, "name_scheme: "},
+		{"bad field_types kind", func(c *Config) { c.FieldTypes = map[string]string{"sets": "double"} }, "field_types: "},
+		{"bad field_types type", func(c *Config) { c.FieldTypes = map[string]string{"all": "string"} }, "field_types: "},
+		{"negative file_max_size", func(c *Config) { c.FileMaxSize = &neg64 }, "file_max_size: "},
+		{"negative file_keep", func(c *Config) { c.FileKeep = &neg }, "file_keep: "},
+		{"bad redis_mode", func(c *Config) { c.RedisMode = "pubsub" }, "redis_mode: "},
+		{"signer without key", func(c *Config) { c.SignerName = "myapp" }, "signer: "},
+		{"signer without name", func(c *Config) { c.SignerKey = "secret" }, "signer: "},
+		{"bad signer_hash", func(c *Config) { c.SignerName, c.SignerKey, c.SignerHash = "myapp", "secret", "sha256" }, "signer: "},
+		{"bad gauge_rates", func(c *Config) { c.GaugeRates = []string{"["} }, "gauge_rates: "},
+		{"bad hybrid_fields", func(c *Config) { c.HybridFields = []string{"["} }, "hybrid_fields: "},
+		{"bad include", func(c *Config) { c.Include = []string{"["} }, "include: "},
+		{"bad exclude", func(c *Config) { c.Exclude = []string{"["} }, "exclude: "},
+		{"percentile of 1", func(c *Config) { c.Percentiles = []float64{0.5, 1} }, "percentiles: "},
+		{"percentile of 0", func(c *Config) { c.Percentiles = []float64{0} }, "percentiles: "},
+		{"bad percentile_overrides pattern", func(c *Config) {
+			c.PercentileOverrides = []PercentileOverride{{Pattern: "[", Percentiles: []float64{0.5}}}
+		}, "percentile_overrides: "},
+		{"bad percentile_overrides percentile", func(c *Config) {
+			c.PercentileOverrides = []PercentileOverride{{Pattern: "api.*", Percentiles: []float64{2}}}
+		}, "percentile_overrides: api.*: "},
+		{"bad sample_rates pattern", func(c *Config) { c.SampleRates = []SampleRate{{Pattern: "[", Every: 2}} }, "sample_rates: "},
+		{"zero sample_rates every", func(c *Config) { c.SampleRates = []SampleRate{{Pattern: "cache.*"}} }, "sample_rates: cache.*: "},
+		{"bad field", func(c *Config) { c.Fields = map[string]interface{}{"x": struct{}{}} }, "fields: x: "},
+	} {
+		c := &Config{Connect: "tcp://127.0.0.1:5565"}
+		test.set(c)
+		err := c.Validate()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !strings.HasPrefix(err.Error(), "config: "+test.err) {
+			t.Errorf("%s: got '%s', expected 'config: %s...'", test.name, err, test.err)
+		}
+	}
+}
+
+func TestConfigValidateStructs(t *testing.T) {
+	c := &Config{
+		Connect:             "tcp://127.0.0.1:5565",
+		Partitions:          []Partition{{Names: []string{"api.*"}, Type: "api", Fields: map[string]interface{}{"team": "web"}}},
+		Routes:              []RouteConfig{{Connect: "udp://127.0.0.1:5565", Kinds: []string{"timers"}}},
+		SampleRates:         []SampleRate{{Pattern: "cache.*", Every: 10}},
+		PercentileOverrides: []PercentileOverride{{Pattern: "api.*", Percentiles: []float64{0.5, 0.999}}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// setenv sets the environment variables in env, returning a func restoring
+// their previous values
+func setenv(t *testing.T, env map[string]string) func() {
+	old := map[string]*string{}
+	for name, value := range env {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name, v := range old {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	defer setenv(t, map[string]string{
+		"HEKAMETRICS_CONNECT":     "udp://127.0.0.1:5565",
+		"HEKAMETRICS_TYPE":        "metrics",
+		"HEKAMETRICS_INTERVAL":    "30s",
+		"HEKAMETRICS_EXCLUDE":     "debug.*, test.*",
+		"HEKAMETRICS_SEVERITY":    "3",
+		"HEKAMETRICS_OMIT_PID":    "true",
+		"HEKAMETRICS_FIELDS":      "environment=production",
+		"HEKAMETRICS_NAN_POLICY":  "skip",
+		"HEKAMETRICS_PERCENTILES": "0.9",
+	})()
+
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Connect != "udp://127.0.0.1:5565" || c.Type != "metrics" || c.Interval != 30*time.Second {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Exclude, []string{"debug.*", "test.*"}) {
+		t.Errorf("exclude: %q", c.Exclude)
+	}
+	if c.Severity == nil || *c.Severity != 3 || !c.OmitPid || c.NaNPolicy != "skip" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if c.Fields["environment"] != "production" || !reflect.DeepEqual(c.Percentiles, []float64{0.9}) {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	// unset variables leave the defaults
+	if c.RateLimit != 0 || c.Hostname != "" || c.MaxMessageSize != nil {
+		t.Errorf("unset settings changed: %+v", c)
+	}
+
+	hc, err := NewHekaClientFromEnv(WithInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc.msgtype != "metrics" || hc.build.nan != NaNSkip || hc.severity != 3 {
+		t.Errorf("settings not applied: %s %v %d", hc.msgtype, hc.build.nan, hc.severity)
+	}
+	// options passed to NewHekaClientFromEnv override the environment
+	if hc.interval != time.Minute {
+		t.Errorf("interval: got %s", hc.interval)
+	}
+}
+
+func TestConfigFromEnvErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, value, err string
+	}{
+		{"HEKAMETRICS_INTERVAL", "soon", "env: HEKAMETRICS_INTERVAL: interval: "},
+		{"HEKAMETRICS_EAGER_CONNECT", "maybe", "env: HEKAMETRICS_EAGER_CONNECT: eager_connect: "},
+		{"HEKAMETRICS_RATE_LIMIT", "fast", "env: HEKAMETRICS_RATE_LIMIT: rate_limit: "},
+		{"HEKAMETRICS_FIELDS", "production", "env: HEKAMETRICS_FIELDS: fields: "},
+		{"HEKAMETRICS_PARTITIONS", "api.*", "env: HEKAMETRICS_PARTITIONS: partitions: "},
+	} {
+		restore := setenv(t, map[string]string{"HEKAMETRICS_CONNECT": "tcp://127.0.0.1:5565", test.name: test.value})
+		_, err := ConfigFromEnv()
+		_, cerr := NewHekaClientFromEnv()
+		restore()
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s=%s: got '%v', expected '%s...'", test.name, test.value, err, test.err)
+		}
+		if cerr == nil {
+			t.Errorf("%s=%s: NewHekaClientFromEnv: expected an error", test.name, test.value)
+		}
+	}
+
+	// values that parse but don't validate fail when creating the client
+	for name, value := range map[string]string{
+		"HEKAMETRICS_CONNECT":    "ftp://127.0.0.1:5565",
+		"HEKAMETRICS_NAN_POLICY": "drop",
+		"HEKAMETRICS_TOP_N":      "-1",
+	} {
+		restore := setenv(t, map[string]string{"HEKAMETRICS_CONNECT": "tcp://127.0.0.1:5565", name: value})
+		_, err := ConfigFromEnv()
+		_, cerr := NewHekaClientFromEnv()
+		restore()
+		if err != nil {
+			t.Errorf("%s=%s: ConfigFromEnv: %s", name, value, err)
+		}
+		if cerr == nil || !strings.HasPrefix(cerr.Error(), "config: ") {
+			t.Errorf("%s=%s: NewHekaClientFromEnv: got '%v'", name, value, cerr)
+		}
+	}
+
+	// empty variables count as unset
+	defer setenv(t, map[string]string{"HEKAMETRICS_CONNECT": ""})()
+	if _, err := NewHekaClientFromEnv(); err == nil || err.Error() != "config: connect: required" {
+		t.Errorf("without HEKAMETRICS_CONNECT: got '%v'", err)
+	}
+}
@@ -0,0 +1,129 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// ReadConfigFile reads a Config from a JSON file, keys are spelled like the
+// Config struct tags and durations are strings:
+//
+//	{"connect": "tcp://heka:5565", "type": "stats", "interval": "10s"}
+//
+// TOML files, in the same style as Heka's own configuration, are read by
+// the tomlconfig package, which keeps the TOML parser out of this one
+func ReadConfigFile(path string) (*Config, error) {
+	if filepath.Ext(path) != ".json" {
+		return nil, fmt.Errorf("%s: not a '.json' file, TOML files are read by github.com/imgix/hekametrics/tomlconfig", path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	c, err := ConfigFromMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// ConfigFromMap builds a Config from settings decoded from a JSON or TOML
+// document, keyed like the Config struct tags, durations being strings
+func ConfigFromMap(raw map[string]interface{}) (*Config, error) {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c := &Config{}
+	for _, key := range keys {
+		if err := c.setAny(key, raw[key]); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadConfig creates a HekaClient configured by the JSON file at path, see
+// ReadConfigFile
+//
+// opts are applied after the settings from the file
+func LoadConfig(path string, opts ...Option) (*HekaClient, error) {
+	c, err := ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return NewHekaClient(c.Connect, c.Type, append(c.options(), opts...)...)
+}
+
+// setAny assigns a decoded JSON or TOML value to the setting named key
+func (c *Config) setAny(key string, value interface{}) error {
+	if s, ok := value.(string); ok {
+		return c.Set(key, s)
+	}
+	f, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("%s: unknown setting", key)
+	}
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(f.Type()) {
+		f.Set(v)
+		return nil
+	}
+	if f.Type() == durationType {
+		return fmt.Errorf("%s: durations must be strings like \"10s\"", key)
+	}
+	// let encoding/json handle conversions like []interface{} to []string
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	if err = json.Unmarshal(b, f.Addr().Interface()); err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes body to name in a new temporary directory
+func writeConfig(t *testing.T, name, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "hekametrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestReadConfigFileJSON(t *testing.T) {
+	path, done := writeConfig(t, "heka.json", `{
+		"connect": "tcp://127.0.0.1:5565",
+		"type": "stats",
+		"interval": "10s",
+		"exclude": ["debug.*"],
+		"percentiles": [0.5, 0.99],
+		"eager_connect": false,
+		"fields": {"environment": "production"}
+	}`)
+	defer done()
+
+	c, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Connect != "tcp://127.0.0.1:5565" || c.Type != "stats" || c.Interval != 10*time.Second {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Exclude, []string{"debug.*"}) {
+		t.Errorf("exclude: got %v", c.Exclude)
+	}
+	if !reflect.DeepEqual(c.Percentiles, []float64{0.5, 0.99}) {
+		t.Errorf("percentiles: got %v", c.Percentiles)
+	}
+	if c.Fields["environment"] != "production" {
+		t.Errorf("fields: got %v", c.Fields)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path, done := writeConfig(t, "heka.json", `{"connect": "tcp://127.0.0.1:5565", "type": "metrics", "interval": "30s"}`)
+	defer done()
+
+	hc, err := LoadConfig(path, WithInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc.msgtype != "metrics" {
+		t.Errorf("type: got %s", hc.msgtype)
+	}
+	// options passed to LoadConfig override the file
+	if hc.interval != time.Minute {
+		t.Errorf("interval: got %s", hc.interval)
+	}
+}
+
+func TestLoadConfigBadFile(t *testing.T) {
+	for _, test := range []struct {
+		name, body, err string
+	}{
+		{"heka.json", `{"connect": `, "heka.json: "},
+		{"heka.json", `{"connect": "tcp://127.0.0.1:5565", "nosuch": 1}`, "nosuch: unknown setting"},
+		{"heka.json", `{"connect": "tcp://127.0.0.1:5565", "interval": 10}`, "interval: durations must be strings"},
+		{"heka.json", `{"connect": "tcp://127.0.0.1:5565", "interval": "soon"}`, "interval: "},
+		{"heka.json", `{"connect": "tcp://127.0.0.1:5565", "percentiles": [2]}`, "config: percentiles"},
+		{"heka.json", `{"type": "stats"}`, "config: connect"},
+		{"heka.toml", `connect = "tcp://127.0.0.1:5565"`, "tomlconfig"},
+	} {
+		path, done := writeConfig(t, test.name, test.body)
+		_, err := LoadConfig(path)
+		done()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.body)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got '%s', expected '%s'", test.body, err, test.err)
+		}
+	}
+
+	if _, err := LoadConfig("/nonexistent/heka.json"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,109 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// writerSender writes to the process's stdout or stderr, which it never
+// closes
+type writerSender struct {
+	w io.Writer
+}
+
+func dialStdout(hc *HekaClient, u *url.URL) (Sender, error) {
+	return writerSender{os.Stdout}, nil
+}
+
+func dialStderr(hc *HekaClient, u *url.URL) (Sender, error) {
+	return writerSender{os.Stderr}, nil
+}
+
+func (s writerSender) SendMessage(b []byte) error {
+	n, err := s.w.Write(b)
+	if err != nil || n != len(b) {
+		return &SendError{Wrote: n, Len: len(b), Err: err}
+	}
+	return nil
+}
+
+func (s writerSender) Close() {}
+
+// encodeText renders each metric field of msg on a line of its own:
+// timestamp, hostname, type, name and value, separated by spaces
+//
+//	2014-06-01T12:00:00Z web1 stats api.latency.timer.mean 1.5e+06
+func encodeText(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b := (*buf)[:0]
+	defer func() { *buf = b }()
+
+	prefix := time.Unix(0, msg.GetTimestamp()).UTC().Format(time.RFC3339Nano) +
+		" " + msg.GetHostname() + " " + msg.GetType() + " "
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		b = append(b, prefix...)
+		b = append(b, f.GetName()...)
+		b = append(b, ' ')
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if v := f.GetValueInteger(); len(v) > 0 {
+				b = strconv.AppendInt(b, v[0], 10)
+			}
+		case message.Field_DOUBLE:
+			if v := f.GetValueDouble(); len(v) > 0 {
+				b = strconv.AppendFloat(b, v[0], 'g', -1, 64)
+			}
+		case message.Field_STRING:
+			if v := f.GetValueString(); len(v) > 0 {
+				b = append(b, v[0]...)
+			}
+		}
+		b = append(b, '\n')
+	}
+	return nil
+}
+
+// encodeJSONLine renders msg as a single line of JSON
+func encodeJSONLine(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b, err := appendJSON((*buf)[:0], msg)
+	if err != nil {
+		return err
+	}
+	*buf = append(b, '\n')
+	return nil
+}
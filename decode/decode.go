@@ -0,0 +1,203 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package decode reads the framed Heka protobuf stream written by hekametrics
+back into Go values, for consumers and tests that need to see what the
+exporter produced
+
+	d := decode.NewDecoder(conn)
+	for {
+		msg, err := d.Next()
+		if err != nil {
+			break
+		}
+		fmt.Println(decode.Values(msg)["api.latency.timer.99-percentile"])
+	}
+*/
+package decode
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"strings"
+)
+
+// Decoder reads framed messages from a stream
+type Decoder struct {
+	r   *bufio.Reader
+	buf []byte
+	// max is the largest message length accepted, 0 for no limit
+	max int
+}
+
+// NewDecoder returns a Decoder reading from r, accepting messages up to
+// Heka's message.MAX_MESSAGE_SIZE
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReaderSize(r, message.MAX_RECORD_SIZE), max: message.MAX_MESSAGE_SIZE}
+}
+
+// SetMaxMessageSize sets the largest message length Next accepts, 0 for no
+// limit, to read streams from clients with a raised or disabled
+// hekametrics.WithMaxMessageSize
+func (d *Decoder) SetMaxMessageSize(n int) {
+	d.max = n
+}
+
+// Next returns the next message in the stream, bytes before a record
+// separator are skipped
+//
+// io.EOF is returned at the end of the stream
+func (d *Decoder) Next() (*message.Message, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == message.RECORD_SEPARATOR {
+			break
+		}
+	}
+	hlen, err := d.r.ReadByte()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if err = d.read(int(hlen) + 1); err != nil {
+		return nil, err
+	}
+	if d.buf[hlen] != message.UNIT_SEPARATOR {
+		return nil, fmt.Errorf("decode: missing unit separator after header")
+	}
+	h := &message.Header{}
+	if err = proto.Unmarshal(d.buf[:hlen], h); err != nil {
+		return nil, fmt.Errorf("decode: header: %s", err)
+	}
+	if d.max > 0 && int64(h.GetMessageLength()) > int64(d.max) {
+		return nil, fmt.Errorf("decode: message length %d exceeds %d",
+			h.GetMessageLength(), d.max)
+	}
+	if err = d.read(int(h.GetMessageLength())); err != nil {
+		return nil, err
+	}
+	msg := &message.Message{}
+	if err = proto.Unmarshal(d.buf, msg); err != nil {
+		return nil, fmt.Errorf("decode: message: %s", err)
+	}
+	return msg, nil
+}
+
+// read fills d.buf with exactly n bytes
+func (d *Decoder) read(n int) error {
+	if cap(d.buf) < n {
+		d.buf = make([]byte, n)
+	}
+	d.buf = d.buf[:n]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Values returns the numeric fields of msg keyed by field name, integers are
+// converted to float64 and non-numeric fields are left out
+func Values(msg *message.Message) map[string]float64 {
+	values := make(map[string]float64, len(msg.GetFields()))
+	for _, f := range msg.GetFields() {
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if v := f.GetValueInteger(); len(v) > 0 {
+				values[f.GetName()] = float64(v[0])
+			}
+		case message.Field_DOUBLE:
+			if v := f.GetValueDouble(); len(v) > 0 {
+				values[f.GetName()] = v[0]
+			}
+		}
+	}
+	return values
+}
+
+// ReadValues decodes every message in r and merges their Values, later
+// messages win
+func ReadValues(r io.Reader) (map[string]float64, error) {
+	values := make(map[string]float64)
+	d := NewDecoder(r)
+	for {
+		msg, err := d.Next()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		for name, v := range Values(msg) {
+			values[name] = v
+		}
+	}
+}
+
+var meterStats = map[string]bool{
+	"count": true, "one-minute": true, "five-minute": true,
+	"fifteen-minute": true, "mean": true,
+}
+
+// Split reverses hekametrics' field naming into the metric name, its kind
+// ('timer', 'histogram', 'meter', empty for counters and gauges) and the
+// statistic, e.g. 'api.latency.timer.99-percentile' is
+// ('api.latency', 'timer', '99-percentile')
+//
+// meters carry no kind in their field names, so a counter or gauge whose name
+// ends in one of the meter statistics, like 'jobs.count', is reported as a
+// meter
+func Split(field string) (metric, kind, stat string) {
+	i := strings.LastIndex(field, ".")
+	if i < 0 {
+		return field, "", ""
+	}
+	metric, stat = field[:i], field[i+1:]
+	for _, k := range []string{"timer", "histogram"} {
+		if strings.HasSuffix(metric, "."+k) {
+			return metric[:len(metric)-len(k)-1], k, stat
+		}
+	}
+	if meterStats[stat] {
+		return metric, "meter", stat
+	}
+	return field, "", ""
+}
@@ -0,0 +1,137 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package decode
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	"github.com/imgix/hekametrics"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"strings"
+	"testing"
+)
+
+// streamSender collects the frames a client sends
+type streamSender struct {
+	bytes.Buffer
+}
+
+func (s *streamSender) SendMessage(b []byte) error {
+	s.Write(b)
+	return nil
+}
+
+func (s *streamSender) Close() {}
+
+// stream returns the frames of two flushes of a registry with n counters
+func stream(t *testing.T, n int, opts ...hekametrics.Option) []byte {
+	s := &streamSender{}
+	opts = append([]hekametrics.Option{hekametrics.WithSender(s)}, opts...)
+	hc, err := hekametrics.NewHekaClient("tcp://127.0.0.1:5565", "stats", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	for i := 0; i < n; i++ {
+		metrics.GetOrRegisterCounter(fmt.Sprintf("requests.%d", i), r).Inc(int64(i))
+	}
+	for i := 0; i < 2; i++ {
+		if err := hc.Flush(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s.Bytes()
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	for name, opts := range map[string][]hekametrics.Option{
+		"unsigned": nil,
+		"signed":   {hekametrics.WithSigner(hekametrics.Signer{Name: "metrics", Key: "secret", KeyVersion: 1})},
+	} {
+		d := NewDecoder(bytes.NewReader(stream(t, 3, opts...)))
+		for i := 0; i < 2; i++ {
+			msg, err := d.Next()
+			if err != nil {
+				t.Fatalf("%s: message %d: %s", name, i, err)
+			}
+			if msg.GetType() != "stats" || Values(msg)["requests.2"] != 2 {
+				t.Errorf("%s: message %d: got type '%s', values %v", name, i, msg.GetType(), Values(msg))
+			}
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("%s: got %v at the end, expected io.EOF", name, err)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	b := stream(t, 3)
+	for _, n := range []int{1, 2, len(b)/2 - 1} {
+		d := NewDecoder(bytes.NewReader(b[:n]))
+		if _, err := d.Next(); err != io.ErrUnexpectedEOF {
+			t.Errorf("%d bytes: got %v, expected io.ErrUnexpectedEOF", n, err)
+		}
+	}
+}
+
+func TestDecoderOversizeHeader(t *testing.T) {
+	header, err := proto.Marshal(&message.Header{MessageLength: proto.Uint32(message.MAX_MESSAGE_SIZE + 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := append([]byte{message.RECORD_SEPARATOR, byte(len(header))}, header...)
+	b = append(b, message.UNIT_SEPARATOR)
+	d := NewDecoder(bytes.NewReader(b))
+	if _, err := d.Next(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("got %v, expected the message length to be rejected", err)
+	}
+}
+
+func TestDecoderMaxMessageSize(t *testing.T) {
+	// well over Heka's limit in a single message
+	b := stream(t, 5000, hekametrics.WithMaxMessageSize(0))
+	if _, err := NewDecoder(bytes.NewReader(b)).Next(); err == nil {
+		t.Fatalf("expected an error for a %d byte stream with the default limit", len(b))
+	}
+	d := NewDecoder(bytes.NewReader(b))
+	d.SetMaxMessageSize(0)
+	for i := 0; i < 2; i++ {
+		msg, err := d.Next()
+		if err != nil {
+			t.Fatalf("message %d: %s", i, err)
+		}
+		if v := Values(msg)["requests.4999"]; v != 4999 {
+			t.Errorf("message %d: requests.4999 is %v", i, v)
+		}
+	}
+}
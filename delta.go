@@ -0,0 +1,171 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"sync"
+)
+
+// CounterMode says whether counters are sent as running totals, as the
+// increase since the previous flush, or both
+type CounterMode int
+
+const (
+	// CounterTotal sends the counter's count, the default
+	CounterTotal CounterMode = iota
+	// CounterTotalAndDelta adds a '<name>.delta' field with the increase
+	// since the previous flush
+	CounterTotalAndDelta
+	// CounterDelta sends the increase since the previous flush in place of
+	// the count
+	CounterDelta
+)
+
+var counterModes = map[string]CounterMode{"total": CounterTotal, "both": CounterTotalAndDelta, "delta": CounterDelta}
+
+// ParseCounterMode parses 'total', 'both' or 'delta'
+func ParseCounterMode(s string) (CounterMode, error) {
+	m, ok := counterModes[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown counter mode '%s', try 'total', 'both' or 'delta'", s)
+	}
+	return m, nil
+}
+
+// history remembers the counter, meter and gauge values of the previous
+// flush of each registry, for deltas and rates
+//
+// the first flush, having nothing to compare with, reports a counter's or
+// meter's whole count as its delta, as does a flush after a count went
+// down, e.g. when a counter was cleared
+type history struct {
+	mu   sync.Mutex
+	last map[int]*flushValues
+}
+
+// flushValues are the values of a registry at a flush
+type flushValues struct {
+	// at is when the registry was read, in nanoseconds
+	at     int64
+	ints   map[string]int64
+	floats map[string]float64
+	// carried holds when the values of metrics skipped by the flush, see
+	// WithSampleRate, were read by an earlier one
+	carried map[string]int64
+}
+
+// readAt returns when the value of the metric name was read
+func (v *flushValues) readAt(name string) int64 {
+	if at, ok := v.carried[name]; ok {
+		return at
+	}
+	return v.at
+}
+
+// remember sets prev, prevFloat and since on the counter, meter and gauge
+// samples of the registry at index reg in a flush reading it at, from the
+// previous flush, and records their values for the next one
+//
+// skipped samples aren't sent, so their previous values are carried over
+// for the flush that sends them next
+func (h *history) remember(reg int, samples []sample, at int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last == nil {
+		h.last = make(map[int]*flushValues)
+	}
+	last := h.last[reg]
+	next := &flushValues{at: at, ints: make(map[string]int64), floats: make(map[string]float64)}
+	for i := range samples {
+		s := &samples[i]
+		if s.skip {
+			if last != nil {
+				next.carry(last, s.name)
+			}
+			continue
+		}
+		var seen bool
+		switch metric := s.metric.(type) {
+		case metrics.Counter:
+			next.ints[s.name] = metric.Count()
+			if last != nil {
+				s.prev, seen = last.ints[s.name]
+			}
+		case metrics.Meter:
+			next.ints[s.name] = metric.Count()
+			if last != nil {
+				s.prev = last.ints[s.name]
+			}
+		case metrics.Gauge:
+			next.ints[s.name] = metric.Value()
+			if last != nil {
+				s.prev, seen = last.ints[s.name]
+			}
+		case metrics.GaugeFloat64:
+			next.floats[s.name] = metric.Value()
+			if last != nil {
+				s.prevFloat, seen = last.floats[s.name]
+			}
+		}
+		if seen && at > last.readAt(s.name) {
+			s.since = float64(at-last.readAt(s.name)) / 1e9
+		}
+	}
+	h.last[reg] = next
+}
+
+// carry copies the value of the metric name from last, if it has one
+func (v *flushValues) carry(last *flushValues, name string) {
+	i, isInt := last.ints[name]
+	f, isFloat := last.floats[name]
+	if !isInt && !isFloat {
+		return
+	}
+	if isInt {
+		v.ints[name] = i
+	}
+	if isFloat {
+		v.floats[name] = f
+	}
+	if v.carried == nil {
+		v.carried = make(map[string]int64)
+	}
+	v.carried[name] = last.readAt(name)
+}
+
+// delta is the increase from prev to count
+func delta(prev, count int64) int64 {
+	if count < prev {
+		return count
+	}
+	return count - prev
+}
@@ -0,0 +1,125 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// SchemaType is the 'Type' of the messages describing metrics, see
+// WithSchema
+const SchemaType = "hekametrics.schema"
+
+// Description is the unit and meaning of a metric
+type Description struct {
+	Unit string
+	Text string
+}
+
+// descriptions holds what Describe registered, for every client
+var descriptions = struct {
+	sync.RWMutex
+	m map[string]Description
+}{m: make(map[string]Description)}
+
+// Describe records the unit and description of the metric name, e.g.
+// Describe("api.latency", "ms", "request latency"), sent in schema messages
+// by clients created WithSchema, describing it again replaces both
+func Describe(name, unit, text string) {
+	descriptions.Lock()
+	descriptions.m[name] = Description{Unit: unit, Text: text}
+	descriptions.Unlock()
+}
+
+// Described returns the description of the metric name and whether it has
+// one
+func Described(name string) (Description, bool) {
+	descriptions.RLock()
+	defer descriptions.RUnlock()
+	d, ok := descriptions.m[name]
+	return d, ok
+}
+
+// schemaFields returns a string field per described metric, named after it
+// with its description as value and its unit as representation, sorted by
+// name, cleaned as invalid says, renamed as scheme says and shortened to max
+func schemaFields(invalid InvalidNamePolicy, scheme NameScheme, max int) ([]*message.Field, error) {
+	descriptions.RLock()
+	all := make(map[string]Description, len(descriptions.m))
+	names := make([]string, 0, len(descriptions.m))
+	for name, d := range descriptions.m {
+		all[name] = d
+		names = append(names, name)
+	}
+	descriptions.RUnlock()
+
+	sort.Strings(names)
+	fields := make([]*message.Field, 0, len(names))
+	for _, name := range names {
+		clean, ok := name, true
+		if !validName(name) {
+			if clean, ok = cleanName(name, invalid); !ok {
+				continue
+			}
+		}
+		f, err := message.NewField(shorten(scheme.rename(clean), max, scheme.sep()), all[name].Text, all[name].Unit)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// schemaMessage returns the schema message for a flush stamped st, or nil
+// when nothing is described or the previous one was sent less than
+// schemaEvery ago
+func (hc *HekaClient) schemaMessage(st stamp) (*message.Message, error) {
+	last := atomic.LoadInt64(&hc.schemaSent)
+	if last != 0 && st.captured-last < int64(hc.schemaEvery) {
+		return nil, nil
+	}
+	if !atomic.CompareAndSwapInt64(&hc.schemaSent, last, st.captured) {
+		// another flush is sending it
+		return nil, nil
+	}
+	fields, err := schemaFields(hc.build.invalidNames, hc.build.scheme, hc.build.maxName)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	msg := hc.envelope(st, SchemaType)
+	for _, f := range fields {
+		msg.AddField(f)
+	}
+	return msg, nil
+}
@@ -0,0 +1,131 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"strconv"
+)
+
+// DuplicatePolicy says what happens when two fields of a message end up
+// with the same name, e.g. a counter 'api.count' and a meter 'api', whose
+// count is also sent as 'api.count'
+//
+// static fields added with WithField and WithPartition keep their names,
+// metrics colliding with them are treated as the duplicates
+type DuplicatePolicy int
+
+const (
+	// DuplicateSuffix renames later duplicates 'name.2', 'name.3' and so on,
+	// or 'name_2' with NamesUnderscore
+	DuplicateSuffix DuplicatePolicy = iota
+	// DuplicateSkip drops later duplicates
+	DuplicateSkip
+	// DuplicateError drops the whole message with a *DuplicateFieldError
+	DuplicateError
+	// DuplicateAllow sends duplicates as they are, without checking for them
+	DuplicateAllow
+)
+
+var duplicatePolicies = map[string]DuplicatePolicy{
+	"suffix": DuplicateSuffix, "skip": DuplicateSkip, "error": DuplicateError, "allow": DuplicateAllow,
+}
+
+// ParseDuplicatePolicy parses 'suffix', 'skip', 'error' or 'allow'
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	p, ok := duplicatePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown duplicate policy '%s', try 'suffix', 'skip', 'error' or 'allow'", s)
+	}
+	return p, nil
+}
+
+// DuplicateFieldError reports a message that wasn't sent because two of its
+// fields share Name
+type DuplicateFieldError struct {
+	Name string
+}
+
+func (e *DuplicateFieldError) Error() string {
+	return fmt.Sprintf("duplicate field '%s'", e.Name)
+}
+
+// dedupe applies the client's DuplicatePolicy to the fields of msg, built
+// for partition p or nil, calling the duplicate hook and logging once per
+// collision
+func (hc *HekaClient) dedupe(msg *message.Message, p *partition) error {
+	if hc.onDuplicate == DuplicateAllow {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(msg.Fields))
+	for _, f := range hc.fields {
+		seen[f.GetName()] = true
+	}
+	if p != nil {
+		for _, f := range p.fields {
+			seen[f.GetName()] = true
+		}
+	}
+	fields := msg.Fields[:0]
+	for _, f := range msg.Fields {
+		if hc.isStatic(f) {
+			fields = append(fields, f)
+			continue
+		}
+		name := f.GetName()
+		if !seen[name] {
+			seen[name] = true
+			fields = append(fields, f)
+			continue
+		}
+
+		hc.countDuplicate()
+		if hc.duplicateHook != nil {
+			hc.duplicateHook(name)
+		}
+		hc.log.Printf("Inject: [error] duplicate field '%s'\n", name)
+		switch hc.onDuplicate {
+		case DuplicateError:
+			return &DuplicateFieldError{Name: name}
+		case DuplicateSuffix:
+			renamed := name
+			for i := 2; seen[renamed]; i++ {
+				renamed = shorten(name+hc.build.scheme.sep()+strconv.Itoa(i), hc.build.maxName, hc.build.scheme.sep())
+			}
+			seen[renamed] = true
+			f.Name = &renamed
+			fields = append(fields, f)
+		}
+	}
+	msg.Fields = fields
+	return nil
+}
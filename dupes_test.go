@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"reflect"
+	"testing"
+)
+
+// fieldNames returns the names of the fields of msg, in order
+func fieldNames(msg *message.Message) []string {
+	var names []string
+	for _, f := range msg.GetFields() {
+		names = append(names, f.GetName())
+	}
+	return names
+}
+
+func TestDuplicatePartitionFields(t *testing.T) {
+	s := &recordingSender{}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(s),
+		WithPartition(Partition{Names: []string{"a.*"}, Fields: map[string]interface{}{"a.tenant": "a"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("a.tenant", r).Inc(1)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.messages(t)
+	if len(msgs) == 0 {
+		t.Fatal("nothing sent")
+	}
+	names := fieldNames(msgs[0])
+	if len(names) != 2 || names[0] != "a.tenant.2" || names[1] != "a.tenant" {
+		t.Fatalf("got fields %v, expected the counter renamed 'a.tenant.2'", names)
+	}
+	if v, ok := msgs[0].Fields[1].GetValue().(string); !ok || v != "a" {
+		t.Errorf("partition field 'a.tenant' is %v", msgs[0].Fields[1].GetValue())
+	}
+}
+
+func TestDuplicatePolicies(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		opts   []Option
+		fields []string
+		err    bool
+	}{
+		{"suffix", []Option{WithField("api.count", "static")},
+			[]string{"api.count.2", "api.count"}, false},
+		{"suffix taken", []Option{WithField("api.count", "static"), WithField("api.count.2", "static")},
+			[]string{"api.count.3", "api.count", "api.count.2"}, false},
+		{"suffix underscore", []Option{WithField("api_count", "static"), WithNameScheme(NamesUnderscore)},
+			[]string{"api_count_2", "api_count"}, false},
+		{"skip", []Option{WithField("api.count", "static"), WithDuplicatePolicy(DuplicateSkip)},
+			[]string{"api.count"}, false},
+		{"error", []Option{WithField("api.count", "static"), WithDuplicatePolicy(DuplicateError)},
+			nil, true},
+		{"allow", []Option{WithField("api.count", "static"), WithDuplicatePolicy(DuplicateAllow)},
+			[]string{"api.count", "api.count"}, false},
+	} {
+		var hooked []string
+		opts := append(test.opts, WithDuplicateHook(func(name string) { hooked = append(hooked, name) }))
+		r := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter("api.count", r).Inc(1)
+		msg, err := MakeMessage(r, opts...)
+		if test.err {
+			if derr, ok := err.(*DuplicateFieldError); !ok || derr.Name != "api.count" {
+				t.Errorf("%s: got %v, expected a DuplicateFieldError", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if names := fieldNames(msg); !reflect.DeepEqual(names, test.fields) {
+			t.Errorf("%s: got fields %v, want %v", test.name, names, test.fields)
+		}
+		if test.name != "allow" && len(hooked) != 1 {
+			t.Errorf("%s: hook called for %v, expected the one duplicate", test.name, hooked)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/binary"
+	"github.com/mozilla-services/heka/message"
+)
+
+// framePrefix is room for the largest framing and header in front of a
+// message: separators, header length, and a header holding only the message
+// length as tag plus varint
+const framePrefix = message.HEADER_FRAMING_SIZE + 1 + binary.MaxVarintLen32
+
+// signedFramePrefix is room for the framing and the largest header, which
+// signed messages need
+const signedFramePrefix = message.HEADER_FRAMING_SIZE + message.MAX_HEADER_SIZE
+
+// streamEncoder frames messages exactly like client.ProtobufEncoder, but
+// marshals each message straight into a reused buffer behind
+// framePrefix bytes of headroom, then writes the framing in front of it
+//
+// this avoids both allocating and copying the marshaled message, which is
+// what a datagram sender then writes as is
+type streamEncoder struct {
+	msg *proto.Buffer
+	buf []byte
+	// signing is set by SetSigner, nil leaves messages unsigned
+	signing *signing
+}
+
+func newStreamEncoder() *streamEncoder {
+	return &streamEncoder{msg: proto.NewBuffer(nil)}
+}
+
+// EncodeMessageStream points outBytes at msg encoded as a Heka stream record,
+// outBytes is only valid until the next call
+func (e *streamEncoder) EncodeMessageStream(msg *message.Message, outBytes *[]byte) error {
+	prefix := framePrefix
+	if e.signing != nil {
+		prefix = signedFramePrefix
+	}
+	if cap(e.buf) < prefix {
+		e.buf = make([]byte, 0, 4096)
+	}
+	e.msg.SetBuf(e.buf[:prefix])
+	if err := e.msg.Marshal(msg); err != nil {
+		return err
+	}
+	b := e.msg.Bytes()
+	// keep whatever the buffer grew to for next time
+	e.buf = b[:0]
+
+	var buf [message.MAX_HEADER_SIZE]byte
+	buf[0] = 0x08 // Header.message_length, field 1 varint
+	header := buf[:1+binary.PutUvarint(buf[1:], uint64(len(b)-prefix))]
+	if e.signing != nil {
+		header = e.signing.appendHeader(header, b[prefix:])
+	}
+	hlen := len(header)
+
+	start := prefix - message.HEADER_FRAMING_SIZE - hlen
+	b[start] = message.RECORD_SEPARATOR
+	b[start+1] = uint8(hlen)
+	copy(b[start+message.HEADER_DELIMITER_SIZE:], header)
+	b[prefix-1] = message.UNIT_SEPARATOR
+	*outBytes = b[start:]
+	return nil
+}
@@ -0,0 +1,133 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	"testing"
+)
+
+// readRecord parses the first Heka stream record in b, returning its
+// header, the marshaled message and what follows the record
+func readRecord(t *testing.T, b []byte) (*message.Header, []byte, []byte) {
+	if len(b) < message.HEADER_FRAMING_SIZE || b[0] != message.RECORD_SEPARATOR {
+		t.Fatalf("no record separator at the start of %x", b)
+	}
+	hlen := int(b[1])
+	end := message.HEADER_DELIMITER_SIZE + hlen
+	if len(b) <= end || b[end] != message.UNIT_SEPARATOR {
+		t.Fatalf("no unit separator after the %d byte header in %x", hlen, b)
+	}
+	header := &message.Header{}
+	if err := proto.Unmarshal(b[message.HEADER_DELIMITER_SIZE:end], header); err != nil {
+		t.Fatalf("header: %s", err)
+	}
+	start := end + 1
+	mlen := int(header.GetMessageLength())
+	if len(b) < start+mlen {
+		t.Fatalf("message length %d, only %d bytes left", mlen, len(b)-start)
+	}
+	return header, b[start : start+mlen], b[start+mlen:]
+}
+
+func testMessage(fields int) *message.Message {
+	msg := &message.Message{}
+	msg.SetUuid(make([]byte, 16))
+	msg.SetTimestamp(1e18)
+	msg.SetType("stats")
+	msg.SetLogger("hekametrics")
+	msg.SetSeverity(6)
+	msg.SetHostname("web1")
+	for i := 0; i < fields; i++ {
+		f, _ := message.NewField("metric."+string(rune('a'+i%26)), int64(i), "")
+		msg.AddField(f)
+	}
+	return msg
+}
+
+func TestStreamEncoderRoundTrip(t *testing.T) {
+	e := newStreamEncoder()
+	var stream []byte
+	// sizes around the one byte message length varint and the buffer's
+	// initial capacity
+	sizes := []int{0, 1, 5, 300}
+	for _, n := range sizes {
+		var b []byte
+		if err := e.EncodeMessageStream(testMessage(n), &b); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+	}
+
+	for _, n := range sizes {
+		var header *message.Header
+		var m []byte
+		header, m, stream = readRecord(t, stream)
+		if header.GetHmacSigner() != "" || header.GetHmac() != nil {
+			t.Errorf("unsigned message with a signed header")
+		}
+		msg := &message.Message{}
+		if err := proto.Unmarshal(m, msg); err != nil {
+			t.Fatalf("%d fields: %s", n, err)
+		}
+		if msg.GetType() != "stats" || msg.GetHostname() != "web1" || msg.GetTimestamp() != 1e18 {
+			t.Errorf("%d fields: headers changed: %s %s %d", n, msg.GetType(), msg.GetHostname(), msg.GetTimestamp())
+		}
+		if len(msg.GetFields()) != n {
+			t.Errorf("got %d fields, want %d", len(msg.GetFields()), n)
+		}
+		for i, f := range msg.GetFields() {
+			if f.GetValue() != int64(i) {
+				t.Errorf("field %d: got %v", i, f.GetValue())
+				break
+			}
+		}
+	}
+	if len(stream) != 0 {
+		t.Errorf("%d bytes left over", len(stream))
+	}
+}
+
+func TestStreamEncoderMatchesMarshal(t *testing.T) {
+	msg := testMessage(3)
+	want, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	if err = newStreamEncoder().EncodeMessageStream(msg, &b); err != nil {
+		t.Fatal(err)
+	}
+	_, m, _ := readRecord(t, b)
+	if string(m) != string(want) {
+		t.Errorf("framed message differs from proto.Marshal")
+	}
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrEndpointQuery is the ConnectError for SetEndpoint given a connect
+// string with query parameters, which only NewHekaClient applies
+var ErrEndpointQuery = errors.New("query parameters can't be changed")
+
+// SetEndpoint points the client at connect from the next flush on, closing
+// the current connection, without stopping LogHeka, e.g. when the Heka
+// aggregator moves
+//
+// connect is checked like NewHekaClient's but can't have query parameters,
+// settings stay as they were, so connect must be able to send what they
+// build, and on error the client keeps its endpoint
+func (hc *HekaClient) SetEndpoint(connect string) error {
+	u, err := parseConnect(connect)
+	if err != nil {
+		return err
+	}
+	if u.RawQuery != "" {
+		return &ConnectError{connect, ErrEndpointQuery, "set them when creating the client"}
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if err := hc.checkEndpoint(u); err != nil {
+		return err
+	}
+	hc.closeSender()
+	hc.connect_s = u
+	hc.scheme = schemes[u.Scheme]
+	hc.rr.reset(u)
+	hc.fallback.reset()
+	hc.log.Printf("Endpoint: %s\n", redacted(u))
+	return nil
+}
+
+// checkEndpoint fails when the client's settings rule out u, as they
+// would have in NewHekaClient
+func (hc *HekaClient) checkEndpoint(u *url.URL) error {
+	if hc.fallback.after > 0 {
+		if u.Scheme != "tcp" {
+			return fmt.Errorf("udp_fallback: only 'tcp://' clients can fall back")
+		}
+		if strings.Contains(u.Host, ",") {
+			return fmt.Errorf("udp_fallback: clients with several hosts can't fall back")
+		}
+	}
+	old := hc.connect_s
+	hc.connect_s = u
+	defer func() { hc.connect_s = old }()
+	return hc.checkOutputs()
+}
+
+// ReloadEndpointOn calls SetEndpoint with the connect string endpoint
+// returns whenever a signal arrives on c, until Stop is called, e.g. to move
+// to a new aggregator on SIGHUP:
+//
+//	c := make(chan os.Signal, 1)
+//	signal.Notify(c, syscall.SIGHUP)
+//	go hc.ReloadEndpointOn(c, readConnectFromFile)
+//
+// errors from endpoint and SetEndpoint are logged and the client keeps its
+// endpoint
+func (hc *HekaClient) ReloadEndpointOn(c <-chan os.Signal, endpoint func() (string, error)) {
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-c:
+		}
+		connect, err := endpoint()
+		if err == nil {
+			err = hc.SetEndpoint(connect)
+		}
+		if err != nil {
+			hc.log.Printf("Endpoint: [error] %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetEndpointOutputs(t *testing.T) {
+	for _, test := range []struct {
+		key     string
+		opt     Option
+		connect string
+	}{
+		{"multi_value_fields", WithMultiValueFields(), "graphite://127.0.0.1:2003"},
+		{"multi_value_fields", WithMultiValueFields(), "influx+udp://127.0.0.1:8089"},
+		{"hybrid_payload", WithHybridPayload("*.count"), "statsd://127.0.0.1:8125"},
+		{"tree_payload", WithTreePayload(), "collectd://127.0.0.1:25826"},
+		{"statmetric", WithStatmetric(), "graphite://127.0.0.1:2003"},
+	} {
+		hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = hc.SetEndpoint(test.connect)
+		if err == nil || !strings.HasPrefix(err.Error(), test.key+": ") {
+			t.Errorf("%s %s: got '%v', expected an error", test.key, test.connect, err)
+		}
+		if got := hc.connect_s.String(); got != "tcp://127.0.0.1:5565" {
+			t.Errorf("%s %s: endpoint changed to %s", test.key, test.connect, got)
+		}
+		if err := hc.SetEndpoint("udp://127.0.0.1:5565"); err != nil {
+			t.Errorf("%s: %v", test.key, err)
+		}
+	}
+}
+
+func TestSetEndpointFallback(t *testing.T) {
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithUDPFallback(time.Minute, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, connect := range []string{"tcp://127.0.0.1:5565,127.0.0.1:5566", "udp://127.0.0.1:5565"} {
+		err := hc.SetEndpoint(connect)
+		if err == nil || !strings.HasPrefix(err.Error(), "udp_fallback: ") {
+			t.Errorf("%s: got '%v', expected an error", connect, err)
+		}
+		if hc.fallback.after != time.Minute || len(hc.rr.hosts) != 0 {
+			t.Errorf("%s: fallback settings changed", connect)
+		}
+	}
+	if err := hc.SetEndpoint("tcp://127.0.0.2:5565"); err != nil {
+		t.Error(err)
+	}
+	if hc.fallback.after != time.Minute {
+		t.Errorf("fallback dropped for a single tcp host")
+	}
+}
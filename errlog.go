@@ -0,0 +1,140 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogSuppression is how long repeats of the same log line are held
+// back before a summary is written
+const DefaultLogSuppression = 10 * time.Minute
+
+// errLogger writes the first occurrence of a log line and then collapses
+// repeats of it into a single summary per window, so a dead Heka endpoint
+// doesn't flood stderr every interval
+//
+// lines are considered the same if they share a format string
+type errLogger struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]*errEntry
+	now    func() time.Time
+	// recent holds the last recentErrors error lines, suppressed or not
+	recent []LoggedError
+}
+
+// recentErrors is how many error lines errLogger keeps for Health
+const recentErrors = 10
+
+// LoggedError is an error the client logged
+type LoggedError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// isError reports whether lines written with format are errors
+func isError(format string) bool {
+	return strings.Contains(format, "[error]") || strings.HasPrefix(format, "Err ")
+}
+
+type errEntry struct {
+	start      time.Time
+	last       string
+	suppressed int
+}
+
+func newErrLogger(window time.Duration) *errLogger {
+	return &errLogger{window: window, seen: make(map[string]*errEntry), now: time.Now}
+}
+
+func (l *errLogger) Printf(format string, v ...interface{}) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if isError(format) {
+		if len(l.recent) == recentErrors {
+			l.recent = append(l.recent[:0], l.recent[1:]...)
+		}
+		l.recent = append(l.recent, LoggedError{now, strings.TrimSpace(fmt.Sprintf(format, v...))})
+	}
+	if l.window <= 0 {
+		logger.Printf(format, v...)
+		return
+	}
+	e := l.seen[format]
+	if e != nil && now.Sub(e.start) < l.window {
+		e.suppressed++
+		e.last = fmt.Sprintf(format, v...)
+		return
+	}
+	if e != nil {
+		l.summarize(e, now)
+	}
+	l.seen[format] = &errEntry{start: now}
+	logger.Printf(format, v...)
+}
+
+// errors returns a copy of the recent error lines, oldest first
+func (l *errLogger) errors() []LoggedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]LoggedError(nil), l.recent...)
+}
+
+// expire writes summaries for any windows that have elapsed, so suppressed
+// counts are reported even once the errors stop
+func (l *errLogger) expire() {
+	if l.window <= 0 {
+		return
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for format, e := range l.seen {
+		if now.Sub(e.start) >= l.window {
+			l.summarize(e, now)
+			delete(l.seen, format)
+		}
+	}
+}
+
+func (l *errLogger) summarize(e *errEntry, now time.Time) {
+	if e.suppressed == 0 {
+		return
+	}
+	logger.Printf("repeated %d times in the last %s, last: %s\n",
+		e.suppressed, now.Sub(e.start)/time.Second*time.Second,
+		strings.TrimSpace(e.last))
+}
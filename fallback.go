@@ -0,0 +1,114 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"net/url"
+	"time"
+)
+
+// udpFallback holds the state of WithUDPFallback, guarded by hc.mu
+type udpFallback struct {
+	// after is how long tcp must be unreachable before falling back, 0
+	// turns fallback off
+	after time.Duration
+	// addr is the udp address, the tcp one when empty
+	addr string
+
+	// down is when dialing tcp started failing, zero while it works
+	down time.Time
+	// since is when the client fell back, zero while it's on tcp
+	since time.Time
+}
+
+func (f *udpFallback) active() bool {
+	return !f.since.IsZero()
+}
+
+// url returns the udp address to fall back to for the tcp endpoint u
+func (f *udpFallback) url(u *url.URL) *url.URL {
+	host := f.addr
+	if host == "" {
+		host = u.Host
+	}
+	return &url.URL{Scheme: "udp", Host: host}
+}
+
+// reset forgets any outage, e.g. when the endpoint changes
+func (f *udpFallback) reset() {
+	f.down, f.since = time.Time{}, time.Time{}
+}
+
+// dialed records the outcome of dialing tcp at now
+func (f *udpFallback) dialed(err error, now time.Time) {
+	switch {
+	case f.active():
+	case err == nil:
+		f.down = time.Time{}
+	case f.down.IsZero():
+		f.down = now
+	}
+}
+
+// checkFallback switches between tcp and udp before a send, hc.mu must be
+// held, falling back once dialing tcp has failed for the fallback period and
+// trying tcp again after as long on udp
+//
+// it runs before anything is encoded, and reconnects never switch on their
+// own, so the messages of a flush are all encoded for the scheme they're
+// sent with
+func (hc *HekaClient) checkFallback() {
+	f := &hc.fallback
+	now := hc.clock.Now()
+	if f.active() {
+		if now.Sub(f.since) < f.after {
+			return
+		}
+		hc.closeSender()
+		f.since = time.Time{}
+		hc.scheme = schemes["tcp"]
+	}
+	if f.down.IsZero() || now.Sub(f.down) < f.after {
+		return
+	}
+	if hc.sender != nil || hc.reconnect() == nil {
+		return
+	}
+
+	f.since = now
+	hc.scheme = schemes["udp"]
+	if hc.reconnect() != nil {
+		f.since = time.Time{}
+		hc.scheme = schemes["tcp"]
+		return
+	}
+	hc.log.Printf("Connecting: %s unreachable for %s, falling back to %s\n",
+		hc.connect_s, now.Sub(f.down), f.url(hc.connect_s))
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldType is the protobuf value type fields of a metric kind are sent as
+type FieldType int
+
+const (
+	// FieldNative keeps integer values as integers and the rest as doubles
+	FieldNative FieldType = iota
+	// FieldInteger sends every value as an integer, rounding doubles, NaN
+	// and infinite values stay doubles
+	FieldInteger
+	// FieldDouble sends every value as a double
+	FieldDouble
+)
+
+var fieldTypes = map[string]FieldType{"native": FieldNative, "integer": FieldInteger, "double": FieldDouble}
+
+// FieldTypePolicy sets the FieldType used for each kind of metric, the zero
+// value sends every kind natively
+type FieldTypePolicy struct {
+	Counters, Gauges, GaugeFloats, Histograms, Meters, Timers FieldType
+}
+
+// AllDoubles sends every metric as doubles, for schemas without integers
+var AllDoubles = FieldTypePolicy{FieldDouble, FieldDouble, FieldDouble, FieldDouble, FieldDouble, FieldDouble}
+
+// kinds maps the names used by ParseFieldTypePolicy to the policy's fields
+func (p *FieldTypePolicy) kinds() map[string]*FieldType {
+	return map[string]*FieldType{
+		"counters":     &p.Counters,
+		"gauges":       &p.Gauges,
+		"gauge_floats": &p.GaugeFloats,
+		"histograms":   &p.Histograms,
+		"meters":       &p.Meters,
+		"timers":       &p.Timers,
+	}
+}
+
+// ParseFieldTypePolicy builds a FieldTypePolicy from kind names, 'counters',
+// 'gauges', 'gauge_floats', 'histograms', 'meters', 'timers' or 'all' for
+// every kind, to 'native', 'integer' or 'double'
+//
+// 'all' is applied first, so the other kinds override it
+func ParseFieldTypePolicy(m map[string]string) (p FieldTypePolicy, err error) {
+	kinds := p.kinds()
+	if v, ok := m["all"]; ok {
+		t, ok := fieldTypes[v]
+		if !ok {
+			return p, fmt.Errorf("all: unknown field type '%s', try 'native', 'integer' or 'double'", v)
+		}
+		for _, k := range kinds {
+			*k = t
+		}
+	}
+	for name, v := range m {
+		if name == "all" {
+			continue
+		}
+		k, ok := kinds[name]
+		if !ok {
+			return p, fmt.Errorf("unknown metric kind '%s', try %s", name, kindNames())
+		}
+		t, ok := fieldTypes[v]
+		if !ok {
+			return p, fmt.Errorf("%s: unknown field type '%s', try 'native', 'integer' or 'double'", name, v)
+		}
+		*k = t
+	}
+	return p, nil
+}
+
+func kindNames() string {
+	var p FieldTypePolicy
+	names := []string{"'all'"}
+	for name := range p.kinds() {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
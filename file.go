@@ -0,0 +1,123 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+const (
+	// DefaultFileMaxSize is the size at which file:// outputs are rotated
+	DefaultFileMaxSize = 64 << 20
+	// DefaultFileKeep is how many rotated files are kept
+	DefaultFileKeep = 5
+)
+
+// fileSender appends to the file at path, renaming it to path.1, path.1 to
+// path.2 and so on up to keep once it reaches maxSize, which is the
+// rotation Heka's LogstreamerInput follows with a 'priority' on the suffix
+//
+// messages are never split across files, so each file can be read on its
+// own by a ProtobufDecoder
+type fileSender struct {
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+// openFile opens the file named by the path of a 'file' connect string
+func openFile(hc *HekaClient, u *url.URL) (Sender, error) {
+	s := &fileSender{path: u.Path, maxSize: hc.fileMaxSize, keep: hc.fileKeep}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSender) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *fileSender) SendMessage(b []byte) error {
+	if s.f == nil {
+		return fmt.Errorf("file %s: closed", s.path)
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	if err != nil || n != len(b) {
+		return &SendError{Wrote: n, Len: len(b), Err: err}
+	}
+	return nil
+}
+
+// rotate shifts the numbered files up by one, dropping the oldest, and
+// starts a new file at path
+func (s *fileSender) rotate() error {
+	s.f.Close()
+	s.f = nil
+	if s.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.keep))
+		for i := s.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSender) Close() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"path"
+)
+
+// nameFilter selects metrics by name with path.Match style patterns
+type nameFilter struct {
+	include, exclude []string
+}
+
+// match reports whether name passes the filter, a name is kept if it matches
+// any include pattern (or there are none) and no exclude pattern
+func (f *nameFilter) match(name string) bool {
+	if matchAny(f.exclude, name) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, name)
+}
+
+func (f *nameFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampleRate has the metrics matching pattern sent with every nth flush
+type sampleRate struct {
+	pattern string
+	every   int64
+}
+
+// skip sets skip on the samples left out of the nth flush, counting from
+// 0, by the first sample rate they match
+func skip(rates []sampleRate, samples []sample, n int64) {
+	for i := range samples {
+		for _, r := range rates {
+			if ok, _ := path.Match(r.pattern, samples[i].name); ok {
+				samples[i].skip = n%r.every != 0
+				break
+			}
+		}
+	}
+}
+
+// sent returns samples without the skipped ones
+func sent(samples []sample) []sample {
+	kept := samples[:0]
+	for _, s := range samples {
+		if !s.skip {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// filteredRegistry hides metrics rejected by match from Each
+type filteredRegistry struct {
+	metrics.Registry
+	match func(string) bool
+}
+
+func (r filteredRegistry) Each(f func(string, interface{})) {
+	r.Registry.Each(func(name string, i interface{}) {
+		if r.match(name) {
+			f(name, i)
+		}
+	})
+}
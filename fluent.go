@@ -0,0 +1,160 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"math"
+)
+
+// encodeForward renders msg as a Fluentd forward protocol event in message
+// mode, [tag, time, record], the record being the same document the json
+// outputs write
+//
+// time is in whole seconds, which every Fluentd version accepts, the
+// record's 'timestamp' keeps the nanoseconds
+func encodeForward(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b := appendMsgpackArray((*buf)[:0], 3)
+	b = appendMsgpackString(b, hc.fluentTag)
+	b = appendMsgpackInt(b, msg.GetTimestamp()/1e9)
+
+	n := 8
+	if msg.Pid == nil {
+		n--
+	}
+	if msg.GetPayload() != "" {
+		n++
+	}
+	b = appendMsgpackMap(b, n)
+	b = appendMsgpackString(b, "uuid")
+	b = appendMsgpackString(b, msg.GetUuidString())
+	b = appendMsgpackString(b, "timestamp")
+	b = appendMsgpackInt(b, msg.GetTimestamp())
+	b = appendMsgpackString(b, "type")
+	b = appendMsgpackString(b, msg.GetType())
+	b = appendMsgpackString(b, "logger")
+	b = appendMsgpackString(b, msg.GetLogger())
+	b = appendMsgpackString(b, "severity")
+	b = appendMsgpackInt(b, int64(msg.GetSeverity()))
+	b = appendMsgpackString(b, "hostname")
+	b = appendMsgpackString(b, msg.GetHostname())
+	if msg.Pid != nil {
+		b = appendMsgpackString(b, "pid")
+		b = appendMsgpackInt(b, int64(msg.GetPid()))
+	}
+	if msg.GetPayload() != "" {
+		b = appendMsgpackString(b, "payload")
+		b = appendMsgpackString(b, msg.GetPayload())
+	}
+	b = appendMsgpackString(b, "fields")
+	b = appendMsgpackMap(b, len(msg.GetFields()))
+	for _, f := range msg.GetFields() {
+		b = appendMsgpackString(b, f.GetName())
+		b = appendMsgpackValue(b, f.GetValue())
+	}
+	*buf = b
+	return nil
+}
+
+// appendMsgpackValue appends the msgpack encoding of a field value
+func appendMsgpackValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendMsgpackString(b, v)
+	case []byte:
+		return appendMsgpackString(b, string(v))
+	case int64:
+		return appendMsgpackInt(b, v)
+	case float64:
+		b = append(b, 0xcb)
+		return appendBigEndian(b, math.Float64bits(v), 8)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	}
+	return append(b, 0xc0)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	if v >= -32 && v < 128 {
+		return append(b, byte(v))
+	}
+	b = append(b, 0xd3)
+	return appendBigEndian(b, uint64(v), 8)
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda)
+		b = appendBigEndian(b, uint64(n), 2)
+	default:
+		b = append(b, 0xdb)
+		b = appendBigEndian(b, uint64(n), 4)
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackArray(b []byte, n int) []byte {
+	return appendMsgpackHeader(b, n, 0x90, 0xdc)
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	return appendMsgpackHeader(b, n, 0x80, 0xde)
+}
+
+// appendMsgpackHeader appends an array or map header, fix is the type byte
+// for up to 15 entries and code that of the 16 bit form, which is followed
+// by the 32 bit one
+func appendMsgpackHeader(b []byte, n int, fix, code byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n < 1<<16:
+		b = append(b, code)
+		return appendBigEndian(b, uint64(n), 2)
+	}
+	b = append(b, code+1)
+	return appendBigEndian(b, uint64(n), 4)
+}
+
+// appendBigEndian appends the low size bytes of v, most significant first
+func appendBigEndian(b []byte, v uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
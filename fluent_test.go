@@ -0,0 +1,188 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bytes"
+	"github.com/mozilla-services/heka/message"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackInt(t *testing.T) {
+	for v, want := range map[int64][]byte{
+		0:    {0x00},
+		127:  {0x7f},
+		-1:   {0xff},
+		-32:  {0xe0},
+		128:  {0xd3, 0, 0, 0, 0, 0, 0, 0, 0x80},
+		-33:  {0xd3, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xdf},
+		1e18: {0xd3, 0x0d, 0xe0, 0xb6, 0xb3, 0xa7, 0x64, 0x00, 0x00},
+	} {
+		if got := appendMsgpackInt(nil, v); !bytes.Equal(got, want) {
+			t.Errorf("%d: got %x, want %x", v, got, want)
+		}
+	}
+}
+
+func TestMsgpackString(t *testing.T) {
+	for n, header := range map[int][]byte{
+		0:       {0xa0},
+		31:      {0xbf},
+		32:      {0xd9, 0x20},
+		255:     {0xd9, 0xff},
+		256:     {0xda, 0x01, 0x00},
+		1 << 16: {0xdb, 0x00, 0x01, 0x00, 0x00},
+	} {
+		s := strings.Repeat("x", n)
+		got := appendMsgpackString(nil, s)
+		if !bytes.HasPrefix(got, header) || string(got[len(header):]) != s {
+			t.Errorf("%d bytes: got header %x, want %x", n, got[:len(header)], header)
+		}
+	}
+}
+
+func TestMsgpackHeaders(t *testing.T) {
+	for _, c := range []struct {
+		got, want []byte
+	}{
+		{appendMsgpackArray(nil, 3), []byte{0x93}},
+		{appendMsgpackArray(nil, 16), []byte{0xdc, 0x00, 0x10}},
+		{appendMsgpackArray(nil, 1<<16), []byte{0xdd, 0x00, 0x01, 0x00, 0x00}},
+		{appendMsgpackMap(nil, 15), []byte{0x8f}},
+		{appendMsgpackMap(nil, 300), []byte{0xde, 0x01, 0x2c}},
+		{appendMsgpackMap(nil, 1<<16), []byte{0xdf, 0x00, 0x01, 0x00, 0x00}},
+	} {
+		if !bytes.Equal(c.got, c.want) {
+			t.Errorf("got %x, want %x", c.got, c.want)
+		}
+	}
+}
+
+func TestMsgpackValue(t *testing.T) {
+	for _, c := range []struct {
+		v    interface{}
+		want []byte
+	}{
+		{"ab", []byte{0xa2, 'a', 'b'}},
+		{[]byte("ab"), []byte{0xa2, 'a', 'b'}},
+		{int64(5), []byte{0x05}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{nil, []byte{0xc0}},
+	} {
+		if got := appendMsgpackValue(nil, c.v); !bytes.Equal(got, c.want) {
+			t.Errorf("%v: got %x, want %x", c.v, got, c.want)
+		}
+	}
+}
+
+func TestEncodeForward(t *testing.T) {
+	hc := newClient("")
+	hc.fluentTag = "metrics"
+	msg := &message.Message{}
+	msg.SetUuid(make([]byte, 16))
+	msg.SetTimestamp(2e9 + 5)
+	msg.SetType("stats")
+	msg.SetLogger("hekametrics")
+	msg.SetSeverity(6)
+	msg.SetHostname("web1")
+	f, _ := message.NewField("api.count", int64(3), "")
+	msg.AddField(f)
+
+	var b []byte
+	if err := encodeForward(hc, msg, &b); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x93, 0xa7}
+	want = append(want, "metrics"...)
+	want = append(want, 0x02, 0x87) // time, 7 keys without a pid
+	want = append(want, 0xa4)
+	want = append(want, "uuid"...)
+	want = append(want, 0xd9, 36)
+	want = append(want, "00000000-0000-0000-0000-000000000000"...)
+	want = append(want, 0xa9)
+	want = append(want, "timestamp"...)
+	want = append(want, 0xd3, 0, 0, 0, 0, 0x77, 0x35, 0x94, 0x05)
+	want = append(want, 0xa4)
+	want = append(want, "type"...)
+	want = append(want, 0xa5)
+	want = append(want, "stats"...)
+	want = append(want, 0xa6)
+	want = append(want, "logger"...)
+	want = append(want, 0xab)
+	want = append(want, "hekametrics"...)
+	want = append(want, 0xa8)
+	want = append(want, "severity"...)
+	want = append(want, 0x06, 0xa8)
+	want = append(want, "hostname"...)
+	want = append(want, 0xa4)
+	want = append(want, "web1"...)
+	want = append(want, 0xa6)
+	want = append(want, "fields"...)
+	want = append(want, 0x81, 0xa9)
+	want = append(want, "api.count"...)
+	want = append(want, 0x03)
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded\n%x\nwant\n%x", b, want)
+	}
+}
+
+func TestEncodeForwardPayload(t *testing.T) {
+	hc := newClient("")
+	hc.fluentTag = "metrics"
+	msg := &message.Message{}
+	msg.SetUuid(make([]byte, 16))
+	msg.SetTimestamp(2e9)
+	msg.SetPid(42)
+	msg.SetPayload(`{"api.count":3}`)
+
+	var b []byte
+	if err := encodeForward(hc, msg, &b); err != nil {
+		t.Fatal(err)
+	}
+	// 9 keys with the pid and the payload
+	if i := bytes.IndexByte(b, 0x89); i != 10 {
+		t.Errorf("record header at %d in %x", i, b)
+	}
+	want := []byte{0xa3}
+	want = append(want, "pid"...)
+	want = append(want, 42, 0xa7)
+	want = append(want, "payload"...)
+	want = append(want, 0xaf)
+	want = append(want, `{"api.count":3}`...)
+	want = append(want, 0xa6)
+	want = append(want, "fields"...)
+	want = append(want, 0x80)
+	if !bytes.HasSuffix(b, want) {
+		t.Errorf("encoded\n%x\nwant it to end with\n%x", b, want)
+	}
+}
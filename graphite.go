@@ -0,0 +1,88 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// encodeGraphite renders the numeric fields of msg as Graphite plaintext
+// lines, 'name value timestamp', using the same names as the Heka fields
+//
+// static fields aren't metrics and are left out
+func encodeGraphite(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b := (*buf)[:0]
+	ts := strconv.FormatInt(msg.GetTimestamp()/1e9, 10)
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		var value string
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if len(f.GetValueInteger()) == 0 {
+				continue
+			}
+			value = strconv.FormatInt(f.GetValueInteger()[0], 10)
+		case message.Field_DOUBLE:
+			if len(f.GetValueDouble()) == 0 {
+				continue
+			}
+			v := f.GetValueDouble()[0]
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			continue
+		}
+		b = append(b, graphiteName(f.GetName())...)
+		b = append(b, ' ')
+		b = append(b, value...)
+		b = append(b, ' ')
+		b = append(b, ts...)
+		b = append(b, '\n')
+	}
+	*buf = b
+	return nil
+}
+
+// graphiteName replaces the characters that would break a plaintext line
+func graphiteName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,99 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Health is what the health endpoint reports about a client
+//
+// a send that fails is counted in SendErrors and the messages dropped
+type Health struct {
+	// Healthy is false when the client is disconnected after having
+	// connected or tried to, or hasn't flushed for three times the interval
+	// LogHeka flushes at, or the one set with WithInterval
+	Healthy bool `json:"healthy"`
+	// Pending is how many messages built by flushes are waiting to be sent,
+	// see WithBackpressure
+	Pending int `json:"pending"`
+	Stats
+	// Sinks are the Stats of the sinks, see SinkStats
+	Sinks map[string]Stats `json:"sinks,omitempty"`
+	// RecentErrors are the last errors logged, oldest first, including those
+	// suppressed from the log
+	RecentErrors []LoggedError `json:"recent_errors"`
+}
+
+// Health returns the client's health
+func (hc *HekaClient) Health() Health {
+	h := Health{Stats: hc.Stats(), Pending: hc.backpressure.waiting(), RecentErrors: hc.log.errors()}
+	if len(hc.sinks) > 0 {
+		h.Sinks = hc.SinkStats()
+	}
+	tried := h.Connects+h.ConnectErrors > 0
+	every := time.Duration(atomic.LoadInt64(&hc.flushEvery))
+	if every <= 0 {
+		every = hc.interval
+	}
+	late := h.Flushes > 0 && hc.clock.Now().Sub(h.LastFlush) > 3*every
+	h.Healthy = (h.Connected || !tried) && !late
+	return h
+}
+
+// HealthHandler returns an http.Handler serving the client's Health as
+// JSON, with status 503 when it isn't healthy, for fleet tooling auditing
+// metric delivery
+func (hc *HekaClient) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := hc.Health()
+		b, err := json.Marshal(h)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(b)
+	})
+}
+
+// ServeHealth listens on addr, e.g. ':9102', and serves HealthHandler on
+// every path until the listener fails, for programs without an HTTP server
+// to mount it on
+func (hc *HekaClient) ServeHealth(addr string) error {
+	s := &http.Server{Addr: addr, Handler: hc.HealthHandler(), ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+	return s.ListenAndServe()
+}
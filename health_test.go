@@ -0,0 +1,185 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a Clock that only moves when advance is called, firing the
+// tickers that come due
+type testClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*testTicker
+}
+
+func newTestClock() *testClock { return &testClock{now: time.Unix(1400000000, 0)} }
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &testTicker{c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// running returns how many tickers were made
+func (c *testClock) running() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+}
+
+type testTicker struct {
+	c    chan time.Time
+	d    time.Duration
+	next time.Time
+}
+
+func (t *testTicker) C() <-chan time.Time { return t.c }
+func (t *testTicker) Stop()               {}
+
+// waitFor polls cond until it's true, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestHealthLogHekaInterval(t *testing.T) {
+	clock := newTestClock()
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(&recordingSender{}), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+
+	go hc.LogHeka(r, time.Minute)
+	defer hc.Stop()
+	waitFor(t, "LogHeka to start", func() bool { return clock.running() > 0 })
+
+	clock.advance(time.Minute)
+	waitFor(t, "a flush", func() bool { return hc.Stats().Flushes == 1 })
+
+	// well past three of WithInterval's default, before the next flush
+	clock.advance(50 * time.Second)
+	if h := hc.Health(); !h.Healthy {
+		t.Errorf("unhealthy between flushes a minute apart: %+v", h)
+	}
+	clock.advance(10 * time.Second)
+	waitFor(t, "a flush", func() bool { return hc.Stats().Flushes == 2 })
+	if h := hc.Health(); !h.Healthy {
+		t.Errorf("unhealthy after a flush: %+v", h)
+	}
+}
+
+func TestHealthLate(t *testing.T) {
+	clock := newTestClock()
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(&recordingSender{}), WithClock(clock), WithInterval(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := hc.Health(); !h.Healthy {
+		t.Errorf("unhealthy before the first flush: %+v", h)
+	}
+	if err = hc.Flush(metrics.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(30 * time.Second)
+	if h := hc.Health(); !h.Healthy {
+		t.Errorf("unhealthy three intervals after a flush: %+v", h)
+	}
+	clock.advance(time.Second)
+	if h := hc.Health(); h.Healthy {
+		t.Errorf("healthy more than three intervals after a flush: %+v", h)
+	}
+}
+
+// blockingSender holds every send until release is closed
+type blockingSender struct {
+	release chan struct{}
+}
+
+func (s *blockingSender) SendMessage(b []byte) error {
+	<-s.release
+	return nil
+}
+
+func (s *blockingSender) Close() {}
+
+func TestHealthPending(t *testing.T) {
+	s := &blockingSender{release: make(chan struct{})}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(s), WithClock(newTestClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+
+	done := make(chan error)
+	go func() { done <- hc.Flush(r) }()
+	waitFor(t, "a pending message", func() bool { return hc.Health().Pending == 1 })
+
+	close(s.release)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if h := hc.Health(); h.Pending != 0 {
+		t.Errorf("pending after the send: %d", h.Pending)
+	}
+}
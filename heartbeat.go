@@ -0,0 +1,63 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+)
+
+// HeartbeatType is the 'Type' of the heartbeat messages, see WithHeartbeat
+const HeartbeatType = "hekametrics.heartbeat"
+
+// heartbeatMessage returns the heartbeat for a flush stamped st, the
+// client's headers and static fields, and its SequenceField and
+// CaptureField when they're on
+func (hc *HekaClient) heartbeatMessage(st stamp) (*message.Message, error) {
+	msg := hc.envelope(st, HeartbeatType)
+	if st.seq != 0 {
+		f, err := message.NewField(SequenceField, st.seq, "count")
+		if err != nil {
+			return nil, err
+		}
+		msg.AddField(f)
+	}
+	if hc.captureField {
+		captured := st.captured + st.offset
+		if hc.fixedTime != nil {
+			captured = *hc.fixedTime
+		}
+		f, err := message.NewField(CaptureField, captured, "ns")
+		if err != nil {
+			return nil, err
+		}
+		msg.AddField(f)
+	}
+	return msg, nil
+}
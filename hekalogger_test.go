@@ -30,9 +30,13 @@
 package hekametrics
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/rcrowley/go-metrics"
 	"math/rand"
+	"runtime"
+	"testing"
 	"time"
 )
 
@@ -165,3 +169,228 @@ func main() {
 	}
 	client.LogHeka(r, time.Second*4)
 }
+
+// benchRegistry returns a registry with n metrics spread over every type
+func benchRegistry(n int) metrics.Registry {
+	r := metrics.NewRegistry()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("bench.metric%d", i)
+		switch i % 6 {
+		case 0:
+			metrics.GetOrRegisterCounter(name, r).Inc(int64(i))
+		case 1:
+			metrics.GetOrRegisterGauge(name, r).Update(int64(i))
+		case 2:
+			g := metrics.NewGaugeFloat64()
+			g.Update(float64(i) / 3)
+			r.Register(name, g)
+		case 3:
+			h := metrics.GetOrRegisterHistogram(name, r, metrics.NewUniformSample(1028))
+			for j := 0; j < 100; j++ {
+				h.Update(int64(j))
+			}
+		case 4:
+			metrics.GetOrRegisterMeter(name, r).Mark(int64(i))
+		case 5:
+			t := metrics.GetOrRegisterTimer(name, r)
+			for j := 0; j < 100; j++ {
+				t.Update(time.Duration(j) * time.Millisecond)
+			}
+		}
+	}
+	return r
+}
+
+// BenchmarkEncode measures building and encoding one flush, which with the
+// stream buffer reused should allocate only for the message itself
+func BenchmarkEncode(b *testing.B) {
+	hc, err := NewHekaClient("udp://127.0.0.1:5565", "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := benchRegistry(100)
+	var frame []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, _ := hc.message(r, 0)
+		if err = hc.scheme.encode(hc, msg, &frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(frame)))
+}
+
+func benchmarkMakeMessage(b *testing.B, n int) {
+	r := benchRegistry(n)
+	o := newBuildOptions()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		make_message(r, &o)
+	}
+}
+
+func BenchmarkMakeMessage100(b *testing.B)   { benchmarkMakeMessage(b, 100) }
+func BenchmarkMakeMessage1000(b *testing.B)  { benchmarkMakeMessage(b, 1000) }
+func BenchmarkMakeMessage10000(b *testing.B) { benchmarkMakeMessage(b, 10000) }
+
+func BenchmarkBuildMessageParallel10000(b *testing.B) {
+	samples := snapshot(benchRegistry(10000))
+	o := newBuildOptions()
+	o.workers = 4
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		build_message(samples, &o)
+	}
+}
+
+// TestSnapshotConcurrentRegistry flushes while other goroutines register,
+// update and unregister metrics, run it with -race
+func TestSnapshotConcurrentRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		go func(w int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				name := fmt.Sprintf("worker%d.metric%d", w, i%50)
+				metrics.GetOrRegisterCounter(name, r).Inc(1)
+				metrics.GetOrRegisterTimer(name+".t", r).Update(time.Millisecond)
+				if i%3 == 0 {
+					r.Unregister(name)
+				}
+			}
+		}(w)
+	}
+
+	o := newBuildOptions()
+	for i := 0; i < 200; i++ {
+		msg := make_message(r, &o)
+		seen := make(map[string]bool, len(msg.Fields))
+		for _, f := range msg.Fields {
+			if seen[f.GetName()] {
+				t.Fatalf("duplicate field %s", f.GetName())
+			}
+			seen[f.GetName()] = true
+		}
+	}
+	close(stop)
+	for w := 0; w < 4; w++ {
+		<-done
+	}
+}
+
+func TestStopWithTimeoutDrained(t *testing.T) {
+	clock := newTestClock()
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(&recordingSender{}), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	go hc.LogHeka(r, time.Second)
+	waitFor(t, "LogHeka to start", func() bool { return clock.running() > 0 })
+
+	// returns after the final flush without the clock moving
+	if n := hc.StopWithTimeout(time.Minute); n != 0 {
+		t.Errorf("%d messages left, want 0", n)
+	}
+	if hc.Stats().Flushes != 1 {
+		t.Errorf("%d flushes, want the final one", hc.Stats().Flushes)
+	}
+}
+
+func TestStopWithTimeoutDeadline(t *testing.T) {
+	clock := newTestClock()
+	s := &blockingSender{release: make(chan struct{})}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(s), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	before := runtime.NumGoroutine()
+
+	flushed := make(chan error)
+	go func() { flushed <- hc.Flush(r) }()
+	waitFor(t, "a pending message", func() bool { return hc.Health().Pending == 1 })
+
+	stopped := make(chan int)
+	go func() { stopped <- hc.StopWithTimeout(time.Minute) }()
+	waitFor(t, "the deadline ticker", func() bool { return clock.running() == 1 })
+	select {
+	case n := <-stopped:
+		t.Fatalf("returned %d before the deadline", n)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.advance(time.Minute)
+	if n := <-stopped; n != 1 {
+		t.Errorf("%d messages left, want 1", n)
+	}
+
+	// only the flush, still blocked in its send, and Stop closing the
+	// connection once it's done are left
+	waitFor(t, "the goroutines to return", func() bool { return runtime.NumGoroutine() <= before+2 })
+	close(s.release)
+	<-flushed
+	waitFor(t, "the connection to close", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+// abandonSender blocks every write until its context is done
+type abandonSender struct {
+	abandoned chan struct{}
+}
+
+func (s *abandonSender) SendMessage(b []byte) error { select {} }
+
+func (s *abandonSender) SendMessageContext(ctx context.Context, b []byte) error {
+	<-ctx.Done()
+	close(s.abandoned)
+	return &SendError{Len: len(b), Err: ctx.Err()}
+}
+
+func (s *abandonSender) Close() {}
+
+func TestStopWithTimeoutAbandonClock(t *testing.T) {
+	clock := newTestClock()
+	s := &abandonSender{abandoned: make(chan struct{})}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(s), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	go hc.LogHeka(r, time.Hour)
+	waitFor(t, "LogHeka to start", func() bool { return clock.running() == 1 })
+
+	stopped := make(chan int)
+	go func() { stopped <- hc.StopWithTimeout(time.Millisecond) }()
+	waitFor(t, "the deadline ticker", func() bool { return clock.running() == 2 })
+	// well past d on the wall clock, the test clock hasn't moved
+	select {
+	case <-s.abandoned:
+		t.Fatal("final flush abandoned before the deadline")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.advance(time.Millisecond)
+	<-stopped
+	<-s.abandoned
+}
+
+func TestStopWithTimeoutZero(t *testing.T) {
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(&recordingSender{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := hc.StopWithTimeout(0); n != 0 {
+		t.Errorf("%d messages left, want 0", n)
+	}
+}
@@ -0,0 +1,101 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"sort"
+	"testing"
+)
+
+// Field returns the value of the numeric field name in msg, integers are
+// converted to float64
+func Field(msg *message.Message, name string) (float64, bool) {
+	if msg == nil {
+		return 0, false
+	}
+	for _, f := range msg.GetFields() {
+		if f.GetName() != name {
+			continue
+		}
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if v := f.GetValueInteger(); len(v) > 0 {
+				return float64(v[0]), true
+			}
+		case message.Field_DOUBLE:
+			if v := f.GetValueDouble(); len(v) > 0 {
+				return v[0], true
+			}
+		}
+	}
+	return 0, false
+}
+
+// AssertField fails t unless msg has the numeric field name and its value
+// is within tolerance of want
+//
+//	hekametricstest.AssertField(t, rec.Last(), "api.latency.timer.99-percentile", 250e6, 1e6)
+func AssertField(t testing.TB, msg *message.Message, name string, want, tolerance float64) {
+	got, ok := Field(msg, name)
+	if !ok {
+		t.Errorf("field %s: missing", name)
+		return
+	}
+	if math.Abs(got-want) > tolerance || math.IsNaN(got) != math.IsNaN(want) {
+		t.Errorf("field %s: got %v, want %v ± %v", name, got, want, tolerance)
+	}
+}
+
+// AssertFields calls AssertField for each name and value in want
+func AssertFields(t testing.TB, msg *message.Message, want map[string]float64, tolerance float64) {
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		AssertField(t, msg, name, want[name], tolerance)
+	}
+}
+
+// AssertNoField fails t if msg has a field called name
+func AssertNoField(t testing.TB, msg *message.Message, name string) {
+	if msg == nil {
+		return
+	}
+	for _, f := range msg.GetFields() {
+		if f.GetName() == name {
+			t.Errorf("field %s: present, want none", name)
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"testing"
+)
+
+// recordingT is a testing.TB keeping the failures reported to it
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func testMessage(t *testing.T) *message.Message {
+	msg := &message.Message{}
+	for name, value := range map[string]interface{}{
+		"requests": int64(5),
+		"latency":  0.25,
+		"idle":     math.NaN(),
+		"host":     "web1",
+	} {
+		f, err := message.NewField(name, value, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.AddField(f)
+	}
+	return msg
+}
+
+func TestField(t *testing.T) {
+	msg := testMessage(t)
+	for _, test := range []struct {
+		name string
+		want float64
+		ok   bool
+	}{
+		{"requests", 5, true},
+		{"latency", 0.25, true},
+		{"host", 0, false},
+		{"nosuch", 0, false},
+	} {
+		got, ok := Field(msg, test.name)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+	if _, ok := Field(nil, "requests"); ok {
+		t.Errorf("field found in a nil message")
+	}
+}
+
+func TestAssertField(t *testing.T) {
+	msg := testMessage(t)
+	for _, test := range []struct {
+		name            string
+		want, tolerance float64
+		fails           bool
+	}{
+		{"requests", 5, 0, false},
+		{"requests", 6, 0, true},
+		{"requests", 6, 1, false},
+		{"latency", 0.2, 0.1, false},
+		{"latency", 0.2, 0.01, true},
+		{"idle", math.NaN(), 0, false},
+		{"idle", 0, 1, true},
+		{"latency", math.NaN(), 1, true},
+		{"host", 0, 0, true},
+		{"nosuch", 0, 0, true},
+	} {
+		rt := &recordingT{}
+		AssertField(rt, msg, test.name, test.want, test.tolerance)
+		if failed := len(rt.errors) > 0; failed != test.fails {
+			t.Errorf("%s %v±%v: failed %v, want %v %q", test.name, test.want, test.tolerance, failed, test.fails, rt.errors)
+		}
+	}
+}
+
+func TestAssertFields(t *testing.T) {
+	msg := testMessage(t)
+	rt := &recordingT{}
+	AssertFields(rt, msg, map[string]float64{"requests": 5, "latency": 0.25}, 0)
+	if len(rt.errors) != 0 {
+		t.Errorf("unexpected failures: %q", rt.errors)
+	}
+	AssertFields(rt, msg, map[string]float64{"requests": 4, "nosuch": 1, "latency": 0.25}, 0)
+	want := []string{"field nosuch: missing", "field requests: got 5, want 4 ± 0"}
+	if fmt.Sprint(rt.errors) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", rt.errors, want)
+	}
+}
+
+func TestAssertNoField(t *testing.T) {
+	msg := testMessage(t)
+	rt := &recordingT{}
+	AssertNoField(rt, msg, "nosuch")
+	AssertNoField(rt, nil, "requests")
+	if len(rt.errors) != 0 {
+		t.Errorf("unexpected failures: %q", rt.errors)
+	}
+	AssertNoField(rt, msg, "host")
+	if len(rt.errors) != 1 {
+		t.Errorf("got %q, want a failure for host", rt.errors)
+	}
+}
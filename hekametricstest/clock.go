@@ -0,0 +1,127 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"github.com/imgix/hekametrics"
+	"sync"
+	"time"
+)
+
+// Clock is a hekametrics.Clock that only moves when Advance is called, so
+// tests can step LogHeka through flushes without sleeping
+//
+//	clock := hekametricstest.NewClock(time.Unix(0, 0))
+//	hc, rec, _ := hekametricstest.NewClient(hekametrics.WithClock(clock))
+//	go hc.LogHeka(registry, time.Second)
+//	clock.WaitTickers(1)
+//	clock.Advance(time.Second)
+type Clock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	tickers []*ticker
+}
+
+// NewClock returns a Clock reading start
+func NewClock(start time.Time) *Clock {
+	c := &Clock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements hekametrics.Clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker implements hekametrics.Clock, the ticker fires as Advance moves
+// past each multiple of d and, like a time.Ticker, drops ticks for a slow
+// receiver
+func (c *Clock) NewTicker(d time.Duration) hekametrics.Ticker {
+	if d <= 0 {
+		panic("hekametricstest: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &ticker{clock: c, c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing any tickers that come due
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+}
+
+// WaitTickers blocks until at least n tickers are running, e.g. until
+// LogHeka has started
+func (c *Clock) WaitTickers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tickers) < n {
+		c.cond.Wait()
+	}
+}
+
+type ticker struct {
+	clock *Clock
+	c     chan time.Time
+	d     time.Duration
+	next  time.Time
+}
+
+func (t *ticker) C() <-chan time.Time { return t.c }
+
+func (t *ticker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			break
+		}
+	}
+}
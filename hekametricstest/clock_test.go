@@ -0,0 +1,119 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"github.com/imgix/hekametrics"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+	"time"
+)
+
+func TestClockTicker(t *testing.T) {
+	start := time.Unix(1400000000, 0)
+	c := NewClock(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now: got %s, want %s", c.Now(), start)
+	}
+	tick := c.NewTicker(time.Second)
+	c.WaitTickers(1)
+
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-tick.C():
+		t.Fatalf("ticked early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	if got := <-tick.C(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("tick: got %s", got)
+	}
+
+	// like a time.Ticker, ticks are dropped for a slow receiver
+	c.Advance(5 * time.Second)
+	if got := <-tick.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("tick: got %s", got)
+	}
+	select {
+	case got := <-tick.C():
+		t.Errorf("extra tick %s", got)
+	default:
+	}
+	if !c.Now().Equal(start.Add(6 * time.Second)) {
+		t.Errorf("Now: got %s", c.Now())
+	}
+
+	tick.Stop()
+	c.Advance(time.Second)
+	select {
+	case got := <-tick.C():
+		t.Errorf("tick %s after Stop", got)
+	default:
+	}
+}
+
+func TestClockNewTickerPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for a zero interval")
+		}
+	}()
+	NewClock(time.Unix(0, 0)).NewTicker(0)
+}
+
+func TestClockLogHeka(t *testing.T) {
+	clock := NewClock(time.Unix(1400000000, 0))
+	hc, rec, err := NewClient(hekametrics.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+
+	done := make(chan struct{})
+	go func() {
+		hc.LogHeka(r, time.Second)
+		close(done)
+	}()
+	clock.WaitTickers(1)
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		for start := time.Now(); len(rec.Messages()) < i; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("timed out waiting for flush %d", i)
+			}
+		}
+	}
+	if got := rec.Last().GetTimestamp(); got != clock.Now().UnixNano() {
+		t.Errorf("timestamp: got %d, want %d", got, clock.Now().UnixNano())
+	}
+	hc.Stop()
+	<-done
+}
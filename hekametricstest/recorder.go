@@ -0,0 +1,165 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package hekametricstest records what a hekametrics.HekaClient sends, so
+applications can test that their metrics are exported under the expected
+names with the expected values
+
+	hc, rec, err := hekametricstest.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Inc(3)
+	hc.Flush(registry)
+	if v, _ := rec.Value("requests"); v != 3 {
+		t.Errorf("requests = %v, want 3", v)
+	}
+*/
+package hekametricstest
+
+import (
+	"bytes"
+	"github.com/imgix/hekametrics"
+	"github.com/imgix/hekametrics/decode"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"sync"
+)
+
+// Recorder is a hekametrics.Sender that decodes and keeps every message
+// written to it, it's safe for concurrent use
+//
+// it understands the Heka protobuf stream, so the client must use a 'tcp'
+// or 'udp' connect string
+type Recorder struct {
+	mu   sync.Mutex
+	msgs []*message.Message
+	err  error
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewClient returns a HekaClient sending to a new Recorder instead of the
+// network, opts are applied after the Recorder is set
+func NewClient(opts ...hekametrics.Option) (*hekametrics.HekaClient, *Recorder, error) {
+	rec := NewRecorder()
+	opts = append([]hekametrics.Option{hekametrics.WithSender(rec)}, opts...)
+	hc, err := hekametrics.NewHekaClient("tcp://127.0.0.1:5565", "hekametricstest", opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hc, rec, nil
+}
+
+// SendMessage decodes the messages framed in b
+//
+// a frame that doesn't decode is returned as the error, and kept for Err,
+// messages of any size are read as the client already applied its
+// WithMaxMessageSize
+func (r *Recorder) SendMessage(b []byte) error {
+	d := decode.NewDecoder(bytes.NewReader(b))
+	d.SetMaxMessageSize(0)
+	var msgs []*message.Message
+	var err error
+	for {
+		var msg *message.Message
+		if msg, err = d.Next(); err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msgs...)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// Close does nothing, a Recorder can be written after it's closed
+func (r *Recorder) Close() {}
+
+// Messages returns the messages recorded so far, oldest first
+func (r *Recorder) Messages() []*message.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*message.Message(nil), r.msgs...)
+}
+
+// Last returns the most recent message, or nil if there's none
+func (r *Recorder) Last() *message.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.msgs) == 0 {
+		return nil
+	}
+	return r.msgs[len(r.msgs)-1]
+}
+
+// Values returns the numeric fields of every recorded message keyed by
+// name, later messages win, see decode.Values
+func (r *Recorder) Values() map[string]float64 {
+	values := make(map[string]float64)
+	for _, msg := range r.Messages() {
+		for name, v := range decode.Values(msg) {
+			values[name] = v
+		}
+	}
+	return values
+}
+
+// Value returns the latest value recorded for the field name
+func (r *Recorder) Value(name string) (float64, bool) {
+	v, ok := r.Values()[name]
+	return v, ok
+}
+
+// Err returns the first decoding error, if any
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Reset forgets the recorded messages and error
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = nil
+	r.err = nil
+}
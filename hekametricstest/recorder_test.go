@@ -0,0 +1,139 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"github.com/imgix/hekametrics"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	hc, rec, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Last() != nil || len(rec.Messages()) != 0 {
+		t.Fatalf("messages before the first flush")
+	}
+	r := metrics.NewRegistry()
+	requests := metrics.GetOrRegisterCounter("requests", r)
+	requests.Inc(3)
+	metrics.GetOrRegisterGauge("queue", r).Update(7)
+	if err = hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	requests.Inc(2)
+	if err = hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := rec.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if rec.Last() != msgs[1] {
+		t.Errorf("Last isn't the latest message")
+	}
+	if v, ok := Field(msgs[0], "requests"); !ok || v != 3 {
+		t.Errorf("first flush: requests = %v, %v", v, ok)
+	}
+	// later messages win
+	if v, ok := rec.Value("requests"); !ok || v != 5 {
+		t.Errorf("requests = %v, %v, want 5", v, ok)
+	}
+	if values := rec.Values(); len(values) != 2 || values["queue"] != 7 {
+		t.Errorf("values: %v", values)
+	}
+	if _, ok := rec.Value("nosuch"); ok {
+		t.Errorf("value recorded for a missing field")
+	}
+	if msgs[0].GetType() != "hekametricstest" {
+		t.Errorf("type: %s", msgs[0].GetType())
+	}
+	if err = rec.Err(); err != nil {
+		t.Error(err)
+	}
+
+	rec.Reset()
+	if rec.Last() != nil || len(rec.Values()) != 0 {
+		t.Errorf("messages kept after Reset")
+	}
+}
+
+// rawSender keeps the bytes written to it
+type rawSender struct {
+	sent []byte
+}
+
+func (s *rawSender) SendMessage(b []byte) error {
+	s.sent = append(s.sent, b...)
+	return nil
+}
+
+func (s *rawSender) Close() {}
+
+func TestRecorderBadFrame(t *testing.T) {
+	raw := &rawSender{}
+	hc, err := hekametrics.NewHekaClient("tcp://127.0.0.1:5565", "stats", hekametrics.WithSender(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	if err = hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// the messages ahead of a frame cut short are kept, bytes outside of
+	// frames are skipped
+	rec := NewRecorder()
+	b := append([]byte("noise"), raw.sent...)
+	b = append(b, raw.sent[:len(raw.sent)-1]...)
+	err = rec.SendMessage(b)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(rec.Messages()) != 1 {
+		t.Errorf("got %d messages, want 1", len(rec.Messages()))
+	}
+	if rec.Err() != err {
+		t.Errorf("Err: got %v, want %v", rec.Err(), err)
+	}
+	rec.SendMessage(raw.sent[:2])
+	if rec.Err() != err {
+		t.Errorf("Err doesn't keep the first error: %v", rec.Err())
+	}
+	rec.Reset()
+	if rec.Err() != nil || len(rec.Messages()) != 0 {
+		t.Errorf("error or messages kept after Reset")
+	}
+}
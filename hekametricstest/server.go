@@ -0,0 +1,177 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/imgix/hekametrics/decode"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"net"
+	"sync"
+)
+
+// Server is a stand-in for a Heka TCP or UDP input, decoding the framed
+// protobuf stream and handing each message to a callback
+type Server struct {
+	addr   net.Addr
+	closer io.Closer
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	err   error
+}
+
+// ListenAndDecode listens on network ('tcp' or 'udp') and addr, e.g.
+// '127.0.0.1:0' for any free port, calling fn with every message received
+//
+// fn is called from the server's goroutines, one per TCP connection, and
+// must be safe for concurrent use
+//
+//	srv, _ := hekametricstest.ListenAndDecode("tcp", "127.0.0.1:0", func(msg *message.Message) {
+//		msgs <- msg
+//	})
+//	defer srv.Close()
+//	hc, _ := hekametrics.NewHekaClient("tcp://"+srv.Addr().String(), "stats")
+func ListenAndDecode(network, addr string, fn func(*message.Message)) (*Server, error) {
+	s := &Server{conns: make(map[net.Conn]bool)}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.addr, s.closer = l.Addr(), l
+		s.wg.Add(1)
+		go s.accept(l, fn)
+	case "udp", "udp4", "udp6":
+		c, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.addr, s.closer = c.LocalAddr(), c
+		s.wg.Add(1)
+		go s.readPackets(c, fn)
+	default:
+		return nil, fmt.Errorf("hekametricstest: unsupported network '%s'", network)
+	}
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.addr
+}
+
+// Err returns the first error decoding a message, if any
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops listening, closes open connections and waits for the
+// server's goroutines to return
+func (s *Server) Close() error {
+	err := s.closer.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept(l net.Listener, fn func(*message.Message)) {
+	defer s.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(c, fn)
+	}
+}
+
+func (s *Server) serve(c net.Conn, fn func(*message.Message)) {
+	defer s.wg.Done()
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+	s.decode(c, fn)
+}
+
+func (s *Server) readPackets(c net.PacketConn, fn func(*message.Message)) {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.decode(bytes.NewReader(buf[:n]), fn)
+	}
+}
+
+// decode passes every message in r to fn, keeping the first error other
+// than the end of the stream
+func (s *Server) decode(r io.Reader, fn func(*message.Message)) {
+	d := decode.NewDecoder(r)
+	for {
+		msg, err := d.Next()
+		if err != nil {
+			if err != io.EOF && !isClosed(err) {
+				s.mu.Lock()
+				if s.err == nil {
+					s.err = err
+				}
+				s.mu.Unlock()
+			}
+			return
+		}
+		fn(msg)
+	}
+}
+
+// isClosed reports whether err comes from reading a connection Close shut
+func isClosed(err error) bool {
+	op, ok := err.(*net.OpError)
+	return ok && op.Err != nil && op.Err.Error() == "use of closed network connection"
+}
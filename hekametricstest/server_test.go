@@ -0,0 +1,120 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametricstest
+
+import (
+	"github.com/imgix/hekametrics"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	for _, network := range []string{"tcp", "udp"} {
+		msgs := make(chan *message.Message, 10)
+		srv, err := ListenAndDecode(network, "127.0.0.1:0", func(msg *message.Message) {
+			msgs <- msg
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hc, err := hekametrics.NewHekaClient(network+"://"+srv.Addr().String(), "stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter("requests", r).Inc(4)
+		for i := 0; i < 2; i++ {
+			if err = hc.Flush(r); err != nil {
+				t.Fatalf("%s: %s", network, err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case msg := <-msgs:
+				AssertField(t, msg, "requests", 4, 0)
+				if msg.GetType() != "stats" {
+					t.Errorf("%s: type %s", network, msg.GetType())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timed out waiting for message %d", network, i)
+			}
+		}
+		if err = srv.Err(); err != nil {
+			t.Errorf("%s: %s", network, err)
+		}
+
+		// Close returns with the client still connected
+		closed := make(chan error)
+		go func() { closed <- srv.Close() }()
+		select {
+		case err = <-closed:
+			if err != nil {
+				t.Errorf("%s: Close: %s", network, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: Close hung", network)
+		}
+		hc.Stop()
+	}
+}
+
+func TestServerBadStream(t *testing.T) {
+	srv, err := ListenAndDecode("tcp", "127.0.0.1:0", func(*message.Message) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a record separator, a 2 byte header without its unit separator
+	c.Write([]byte{message.RECORD_SEPARATOR, 2, 0x08, 0x01, 0xff})
+	c.Close()
+
+	for start := time.Now(); srv.Err() == nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("timed out waiting for a decode error")
+		}
+	}
+}
+
+func TestServerNetwork(t *testing.T) {
+	if _, err := ListenAndDecode("unix", "/tmp/heka.sock", func(*message.Message) {}); err == nil {
+		t.Errorf("expected an error for a unix socket")
+	}
+	if _, err := ListenAndDecode("tcp", "127.0.0.1:bad", func(*message.Message) {}); err == nil {
+		t.Errorf("expected an error for a bad address")
+	}
+}
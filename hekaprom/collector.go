@@ -0,0 +1,130 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package hekaprom exposes a hekametrics.HekaClient's own counters as a
+prometheus.Collector
+
+this lets shops running both systems alert on Heka export failures through
+Prometheus
+
+	hc, _ := hekametrics.NewHekaClient("tcp://127.0.0.1:5565", "stats")
+	prometheus.MustRegister(hekaprom.NewCollector(hc, "myapp"))
+*/
+package hekaprom
+
+import (
+	"github.com/imgix/hekametrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements prometheus.Collector for a HekaClient
+type Collector struct {
+	hc *hekametrics.HekaClient
+
+	connected, connects, connectErrors, flushes, messagesSent, bytesSent,
+	sendErrors, encodeErrors, lastFlush, lastFlushDuration, nonFinite, shortWrites, connResets, duplicates, invalidNames *prometheus.Desc
+}
+
+// NewCollector returns a Collector reporting the Stats of hc
+//
+// metric names are prefixed with namespace, e.g. 'myapp_hekametrics_send_errors_total'
+func NewCollector(hc *hekametrics.HekaClient, namespace string) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "hekametrics", name), help, nil, nil)
+	}
+	return &Collector{
+		hc:                hc,
+		connected:         desc("connected", "Whether the client currently holds a connection to Heka."),
+		connects:          desc("connects_total", "Successful connections made to Heka."),
+		connectErrors:     desc("connect_errors_total", "Failed attempts to connect to Heka."),
+		flushes:           desc("flushes_total", "Registry flushes attempted."),
+		messagesSent:      desc("messages_sent_total", "Messages successfully sent to Heka."),
+		bytesSent:         desc("sent_bytes_total", "Bytes successfully sent to Heka."),
+		sendErrors:        desc("send_errors_total", "Messages that failed to send."),
+		encodeErrors:      desc("encode_errors_total", "Messages that failed to encode."),
+		lastFlush:         desc("last_flush_timestamp_seconds", "Unix time the last flush started."),
+		lastFlushDuration: desc("last_flush_duration_seconds", "Time taken by the last flush."),
+		nonFinite:         desc("non_finite_values_total", "NaN and infinite values seen while building messages."),
+		shortWrites:       desc("short_writes_total", "Writes that sent only part of a message."),
+		connResets:        desc("conn_resets_total", "Writes that failed because Heka reset the connection."),
+		duplicates:        desc("duplicate_fields_total", "Fields whose names collided with another field in the same message."),
+		invalidNames:      desc("invalid_names_total", "Metric names with invalid UTF-8 or control characters seen while building messages."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connected
+	ch <- c.connects
+	ch <- c.connectErrors
+	ch <- c.flushes
+	ch <- c.messagesSent
+	ch <- c.bytesSent
+	ch <- c.sendErrors
+	ch <- c.encodeErrors
+	ch <- c.lastFlush
+	ch <- c.lastFlushDuration
+	ch <- c.nonFinite
+	ch <- c.shortWrites
+	ch <- c.connResets
+	ch <- c.duplicates
+	ch <- c.invalidNames
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.hc.Stats()
+
+	connected := 0.0
+	if s.Connected {
+		connected = 1
+	}
+	lastFlush := 0.0
+	if !s.LastFlush.IsZero() {
+		lastFlush = float64(s.LastFlush.UnixNano()) / 1e9
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, connected)
+	ch <- prometheus.MustNewConstMetric(c.connects, prometheus.CounterValue, float64(s.Connects))
+	ch <- prometheus.MustNewConstMetric(c.connectErrors, prometheus.CounterValue, float64(s.ConnectErrors))
+	ch <- prometheus.MustNewConstMetric(c.flushes, prometheus.CounterValue, float64(s.Flushes))
+	ch <- prometheus.MustNewConstMetric(c.messagesSent, prometheus.CounterValue, float64(s.MessagesSent))
+	ch <- prometheus.MustNewConstMetric(c.bytesSent, prometheus.CounterValue, float64(s.BytesSent))
+	ch <- prometheus.MustNewConstMetric(c.sendErrors, prometheus.CounterValue, float64(s.SendErrors))
+	ch <- prometheus.MustNewConstMetric(c.encodeErrors, prometheus.CounterValue, float64(s.EncodeErrors))
+	ch <- prometheus.MustNewConstMetric(c.lastFlush, prometheus.GaugeValue, lastFlush)
+	ch <- prometheus.MustNewConstMetric(c.lastFlushDuration, prometheus.GaugeValue, s.LastFlushDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.nonFinite, prometheus.CounterValue, float64(s.NonFiniteValues))
+	ch <- prometheus.MustNewConstMetric(c.shortWrites, prometheus.CounterValue, float64(s.ShortWrites))
+	ch <- prometheus.MustNewConstMetric(c.connResets, prometheus.CounterValue, float64(s.ConnResets))
+	ch <- prometheus.MustNewConstMetric(c.duplicates, prometheus.CounterValue, float64(s.DuplicateFields))
+	ch <- prometheus.MustNewConstMetric(c.invalidNames, prometheus.CounterValue, float64(s.InvalidNames))
+}
@@ -0,0 +1,134 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/json"
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"strings"
+)
+
+// TreeValue is the key a metric's own value is nested under in a tree
+// payload when its name also prefixes other fields, e.g. a counter 'api'
+// next to a timer 'api.latency'
+const TreeValue = "_value"
+
+// hybrid moves the metric fields of msg into a compact JSON object in its
+// payload, keyed by field name, leaving as fields only the static fields
+// and the metric fields matching keep
+//
+// NaN and infinite values become null in the payload, like in the JSON
+// outputs, fields with several values, see WithMultiValueFields, become
+// arrays, and with WithTreePayload the object is nested by the dotted
+// parts of the names
+func hybrid(hc *HekaClient, msg *message.Message, keep []string) error {
+	snapshot := make(map[string]interface{}, len(msg.Fields))
+	fields := msg.Fields[:0]
+	for _, f := range msg.Fields {
+		if hc.isStatic(f) {
+			fields = append(fields, f)
+			continue
+		}
+		snapshot[f.GetName()] = payloadValue(f)
+		if matchAny(keep, f.GetName()) {
+			fields = append(fields, f)
+		}
+	}
+	var v interface{} = snapshot
+	if hc.tree {
+		v = tree(snapshot)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg.SetPayload(string(payload))
+	msg.Fields = fields
+	return nil
+}
+
+// payloadValue returns the value of f for the payload, or all of them when
+// it has several
+func payloadValue(f *message.Field) interface{} {
+	if d := f.GetValueDouble(); len(d) > 1 {
+		vs := make([]interface{}, len(d))
+		for i, v := range d {
+			vs[i] = finite(v)
+		}
+		return vs
+	}
+	if n := f.GetValueInteger(); len(n) > 1 {
+		return n
+	}
+	v := f.GetValue()
+	if d, ok := v.(float64); ok {
+		return finite(d)
+	}
+	return v
+}
+
+// finite returns d, or nil for NaN and infinite values, which JSON can't
+// hold
+func finite(d float64) interface{} {
+	if math.IsNaN(d) || math.IsInf(d, 0) {
+		return nil
+	}
+	return d
+}
+
+// tree nests the values of flat under the dot separated parts of their
+// names, {"api.latency.timer.count": 1} becoming
+// {"api": {"latency": {"timer": {"count": 1}}}}
+func tree(flat map[string]interface{}) map[string]interface{} {
+	root := make(map[string]interface{})
+	for name, v := range flat {
+		node := root
+		parts := strings.Split(name, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				if leaf, found := node[part]; found {
+					child[TreeValue] = leaf
+				}
+				node[part] = child
+			}
+			node = child
+		}
+		last := parts[len(parts)-1]
+		if child, ok := node[last].(map[string]interface{}); ok {
+			child[TreeValue] = v
+		} else {
+			node[last] = v
+		}
+	}
+	return root
+}
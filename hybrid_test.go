@@ -0,0 +1,66 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/json"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+	"time"
+)
+
+func TestHybridMultiValues(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	api := metrics.GetOrRegisterTimer("api", r)
+	api.Update(2 * time.Millisecond)
+	api.Update(4 * time.Millisecond)
+
+	msg, err := MakeMessage(r, WithMultiValueFields(), WithHybridPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.GetPayload()), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["requests"] != float64(3) {
+		t.Errorf("requests: got %v, expected 3", payload["requests"])
+	}
+	values, ok := payload["api.timer"].([]interface{})
+	// the percentiles, then mean, std dev, the four rates, count, min and
+	// max
+	if !ok || len(values) != len(DefaultPercentiles)+9 {
+		t.Fatalf("api.timer: got %v, expected every statistic", payload["api.timer"])
+	}
+	if mean := values[len(DefaultPercentiles)]; mean != float64(3e6) {
+		t.Errorf("api.timer mean: got %v, expected 3e6", mean)
+	}
+}
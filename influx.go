@@ -0,0 +1,154 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// encodeInflux renders each metric field of msg as an InfluxDB line protocol
+// point named after the field, with a single 'value' field and the static
+// fields as tags
+func encodeInflux(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	var tags []byte
+	for _, f := range msg.GetFields() {
+		if !hc.isTag(f) {
+			continue
+		}
+		value := fmt.Sprint(f.GetValue())
+		if value == "" {
+			// influx rejects empty tag values
+			continue
+		}
+		tags = append(tags, ',')
+		tags = append(tags, influxEscape(f.GetName(), ",= ")...)
+		tags = append(tags, '=')
+		tags = append(tags, influxEscape(value, ",= ")...)
+	}
+	ts := strconv.FormatInt(msg.GetTimestamp(), 10)
+
+	b := (*buf)[:0]
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		var value string
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if len(f.GetValueInteger()) == 0 {
+				continue
+			}
+			value = strconv.FormatInt(f.GetValueInteger()[0], 10) + "i"
+		case message.Field_DOUBLE:
+			if len(f.GetValueDouble()) == 0 {
+				continue
+			}
+			v := f.GetValueDouble()[0]
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			continue
+		}
+		b = append(b, influxEscape(f.GetName(), ", ")...)
+		b = append(b, tags...)
+		b = append(b, " value="...)
+		b = append(b, value...)
+		b = append(b, ' ')
+		b = append(b, ts...)
+		b = append(b, '\n')
+	}
+	*buf = b
+	return nil
+}
+
+// influxEscape backslash escapes each of chars in s
+func influxEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// httpSender POSTs each payload to a fixed URL, shown in errors without
+// its password
+type httpSender struct {
+	client *http.Client
+	url    string
+	shown  string
+}
+
+// dialInfluxHTTP targets the /write endpoint of the InfluxDB at u, the
+// database is taken from the path
+func dialInfluxHTTP(hc *HekaClient, u *url.URL) (Sender, error) {
+	db := strings.Trim(u.Path, "/")
+	if db == "" {
+		return nil, fmt.Errorf("influx+http: database missing, try 'influx+http://<host>:<port>/<database>'")
+	}
+	w := url.URL{Scheme: "http", Host: u.Host, Path: "/write", User: u.User,
+		RawQuery: url.Values{"db": {db}, "precision": {"ns"}}.Encode()}
+	client := &http.Client{Timeout: hc.timeout}
+	if hc.dialFunc != nil {
+		client.Transport = &http.Transport{DialContext: hc.dialFunc}
+	}
+	return &httpSender{client: client, url: w.String(), shown: redacted(&w)}, nil
+}
+
+func (s *httpSender) SendMessage(b []byte) error {
+	resp, err := s.client.Post(s.url, "text/plain; charset=utf-8", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", s.shown, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSender) Close() {}
@@ -0,0 +1,77 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"encoding/json"
+	"github.com/mozilla-services/heka/message"
+	"math"
+)
+
+// jsonMessage is the JSON rendering of a message used by text based outputs
+type jsonMessage struct {
+	Uuid      string                 `json:"uuid"`
+	Timestamp int64                  `json:"timestamp"`
+	Type      string                 `json:"type"`
+	Logger    string                 `json:"logger"`
+	Severity  int32                  `json:"severity"`
+	Hostname  string                 `json:"hostname"`
+	Pid       *int32                 `json:"pid,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+// appendJSON appends the JSON rendering of msg to b, NaN and infinite values
+// become null since JSON can't represent them
+func appendJSON(b []byte, msg *message.Message) ([]byte, error) {
+	m := jsonMessage{
+		Uuid:      msg.GetUuidString(),
+		Timestamp: msg.GetTimestamp(),
+		Type:      msg.GetType(),
+		Logger:    msg.GetLogger(),
+		Severity:  msg.GetSeverity(),
+		Hostname:  msg.GetHostname(),
+		Pid:       msg.Pid,
+		Payload:   msg.GetPayload(),
+		Fields:    make(map[string]interface{}, len(msg.GetFields())),
+	}
+	for _, f := range msg.GetFields() {
+		v := f.GetValue()
+		if d, ok := v.(float64); ok && (math.IsNaN(d) || math.IsInf(d, 0)) {
+			v = nil
+		}
+		m.Fields[f.GetName()] = v
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return b, err
+	}
+	return append(b, j...), nil
+}
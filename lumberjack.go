@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// lumberjackSender sends each message as a window of one JSON event in the
+// lumberjack v2 protocol spoken by Beats, and waits for Logstash to
+// acknowledge it before the send counts as done
+type lumberjackSender struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	buf     []byte
+}
+
+func dialLumberjack(hc *HekaClient, u *url.URL) (Sender, error) {
+	conn, err := hc.dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &lumberjackSender{conn: conn, r: bufio.NewReader(conn), timeout: hc.timeout}, nil
+}
+
+// encodeLumberjack renders msg as the JSON document of a lumberjack event,
+// the sender adds the framing
+func encodeLumberjack(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b, err := appendJSON((*buf)[:0], msg)
+	if err != nil {
+		return err
+	}
+	*buf = b
+	return nil
+}
+
+func (s *lumberjackSender) SendMessage(b []byte) error {
+	if s.timeout > 0 {
+		s.conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	// a window of 1, then the event with sequence number 1
+	s.buf = append(s.buf[:0], '2', 'W')
+	s.buf = appendBigEndian(s.buf, 1, 4)
+	s.buf = append(s.buf, '2', 'J')
+	s.buf = appendBigEndian(s.buf, 1, 4)
+	s.buf = appendBigEndian(s.buf, uint64(len(b)), 4)
+	s.buf = append(s.buf, b...)
+	n, err := s.conn.Write(s.buf)
+	if err != nil || n != len(s.buf) {
+		return &SendError{Wrote: n, Len: len(s.buf), Err: err}
+	}
+	if err = s.ack(1); err != nil {
+		return &SendError{Wrote: n, Len: n, Err: err, Reply: true}
+	}
+	return nil
+}
+
+// ack waits for the acknowledgement of sequence number seq, skipping the
+// lower ones Logstash sends to keep a slow window alive
+func (s *lumberjackSender) ack(seq uint32) error {
+	var frame [6]byte
+	for {
+		if _, err := io.ReadFull(s.r, frame[:]); err != nil {
+			return fmt.Errorf("lumberjack: ack: %s", err)
+		}
+		if frame[0] != '2' || frame[1] != 'A' {
+			return fmt.Errorf("lumberjack: unexpected frame '%c%c'", frame[0], frame[1])
+		}
+		n := uint32(frame[2])<<24 | uint32(frame[3])<<16 | uint32(frame[4])<<8 | uint32(frame[5])
+		if n >= seq {
+			return nil
+		}
+	}
+}
+
+func (s *lumberjackSender) Close() {
+	s.conn.Close()
+}
@@ -0,0 +1,104 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// lumberjackServer reads one window of one event from conn, checks its
+// framing and replies with reply
+func lumberjackServer(t *testing.T, conn net.Conn, event string, reply []byte) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var frame [6]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		t.Errorf("window: %s", err)
+		return
+	}
+	if frame[0] != '2' || frame[1] != 'W' || binary.BigEndian.Uint32(frame[2:]) != 1 {
+		t.Errorf("bad window frame %q", frame)
+	}
+	var data [10]byte
+	if _, err := io.ReadFull(r, data[:]); err != nil {
+		t.Errorf("data: %s", err)
+		return
+	}
+	if data[0] != '2' || data[1] != 'J' || binary.BigEndian.Uint32(data[2:]) != 1 {
+		t.Errorf("bad data frame %q", data[:6])
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(data[6:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Errorf("payload: %s", err)
+		return
+	}
+	if string(payload) != event {
+		t.Errorf("got event %q, want %q", payload, event)
+	}
+	conn.Write(reply)
+}
+
+func TestLumberjackSend(t *testing.T) {
+	for name, reply := range map[string][]byte{
+		"ack":           {'2', 'A', 0, 0, 0, 1},
+		"keepalive+ack": {'2', 'A', 0, 0, 0, 0, '2', 'A', 0, 0, 0, 1},
+	} {
+		client, server := net.Pipe()
+		go lumberjackServer(t, server, `{"type":"stats"}`, reply)
+		s := &lumberjackSender{conn: client, r: bufio.NewReader(client)}
+		if err := s.SendMessage([]byte(`{"type":"stats"}`)); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		s.Close()
+	}
+}
+
+func TestLumberjackBadAck(t *testing.T) {
+	for name, reply := range map[string][]byte{
+		"short":    {'2', 'A', 0},
+		"garbled":  {'2', 'X', 0, 0, 0, 1},
+		"no reply": nil,
+	} {
+		client, server := net.Pipe()
+		go lumberjackServer(t, server, "{}", reply)
+		s := &lumberjackSender{conn: client, r: bufio.NewReader(client)}
+		err := s.SendMessage([]byte("{}"))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if !brokenStream(err) {
+			t.Errorf("%s: connection kept after %s", name, err)
+		}
+		s.Close()
+	}
+}
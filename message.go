@@ -0,0 +1,659 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultPercentiles are the percentiles sent for histograms and timers
+// unless overridden with WithPercentiles
+var DefaultPercentiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// percentileNames names each of ps the way the fields are suffixed, e.g. 0.5
+// is '50-percentile' and 0.999 is '999-percentile'
+func percentileNames(ps []float64) []string {
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		digits := strings.TrimPrefix(strconv.FormatFloat(p, 'f', -1, 64), "0.")
+		if len(digits) == 1 {
+			digits += "0"
+		}
+		names[i] = digits + "-percentile"
+	}
+	return names
+}
+
+func checkPercentiles(ps []float64) error {
+	for _, p := range ps {
+		if p <= 0 || p >= 1 {
+			return fmt.Errorf("%v is not between 0 and 1", p)
+		}
+	}
+	return nil
+}
+
+// percentileOverride is a set of percentiles, and their names, for the
+// histograms and timers matching pattern
+type percentileOverride struct {
+	pattern string
+	ps      []float64
+	pnames  []string
+}
+
+// percentilesFor returns the percentiles, and their names, sent for the
+// metric called name
+func (o *buildOptions) percentilesFor(name string) ([]float64, []string) {
+	for _, p := range o.overrides {
+		if ok, _ := path.Match(p.pattern, name); ok {
+			return p.ps, p.pnames
+		}
+	}
+	return o.percentiles, o.pnames
+}
+
+// names of the statistics sent for each metric type, besides percentiles
+var (
+	histogramStats = []string{"mean", "std-dev"}
+	timerStats     = []string{"mean", "std-dev", "one-minute", "five-minute", "fifteen-minute", "mean-rate"}
+	meterStats     = []string{"one-minute", "five-minute", "fifteen-minute", "mean"}
+)
+
+// sample is a metric as read at the start of a flush
+type sample struct {
+	name   string
+	metric interface{}
+	// prev and prevFloat are the counter or gauge value at the previous
+	// flush, for deltas, and since the seconds since then, 0 if it wasn't
+	// there
+	prev      int64
+	prevFloat float64
+	since     float64
+	// window holds a gauge's values since the previous flush, when they're
+	// sampled in between
+	window *windowStats
+	// stale is set on metrics that haven't changed for a while, see
+	// WithStaleMetrics
+	stale bool
+	// skip is set on metrics left out of this flush, see WithSampleRate
+	skip bool
+}
+
+// byName sorts samples by metric name
+type byName []sample
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// snapshot reads every metric in r in a single pass before any fields are
+// built, so that all fields of a message reflect the same instant
+//
+// the walk with Each only collects the registered metrics and they are
+// snapshotted after it returns, so the registry is held, and the
+// application's Register and Unregister calls blocked, for as short a time
+// as possible
+//
+// metrics registered during the walk may or may not be included, and those
+// unregistered during or after it are still reported, from their final
+// values, in this flush
+func snapshot(r metrics.Registry) []sample {
+	var samples []sample
+	r.Each(func(name string, i interface{}) {
+		samples = append(samples, sample{name: name, metric: i})
+	})
+
+	n := 0
+	for _, s := range samples {
+		switch metric := s.metric.(type) {
+		case metrics.Counter:
+			s.metric = metric.Snapshot()
+		case metrics.Gauge:
+			s.metric = metric.Snapshot()
+		case metrics.GaugeFloat64:
+			s.metric = metric.Snapshot()
+		case metrics.Histogram:
+			s.metric = metric.Snapshot()
+		case metrics.Meter:
+			s.metric = metric.Snapshot()
+		case metrics.Timer:
+			s.metric = metric.Snapshot()
+		default:
+			continue
+		}
+		samples[n] = s
+		n++
+	}
+	return samples[:n]
+}
+
+// NaNPolicy says what to do with NaN and infinite values, which
+// histograms and timers produce for empty samples and which some decoders
+// downstream of Heka choke on
+type NaNPolicy int
+
+const (
+	// NaNSend sends the values as they are
+	NaNSend NaNPolicy = iota
+	// NaNSkip leaves the field out of the message
+	NaNSkip
+	// NaNZero sends 0 instead
+	NaNZero
+)
+
+var nanPolicies = map[string]NaNPolicy{"send": NaNSend, "skip": NaNSkip, "zero": NaNZero}
+
+// ParseNaNPolicy parses 'send', 'skip' or 'zero'
+func ParseNaNPolicy(s string) (NaNPolicy, error) {
+	p, ok := nanPolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown NaN policy '%s', try 'send', 'skip' or 'zero'", s)
+	}
+	return p, nil
+}
+
+// LargeIntPolicy says how integer fields beyond ±2^53 are sent
+//
+// consumers that hold numbers as doubles, like Heka's Lua sandboxes, lose
+// precision past 2^53, and values near the int64 bounds round to 2^63 and
+// wrap around to large negative spikes when converted back
+type LargeIntPolicy int
+
+const (
+	// LargeIntSend sends them as integers like any other value
+	LargeIntSend LargeIntPolicy = iota
+	// LargeIntDouble sends them as double fields
+	LargeIntDouble
+	// LargeIntString sends them as decimal string fields
+	LargeIntString
+)
+
+// maxExactInt is the largest magnitude a double holds exactly
+const maxExactInt = 1 << 53
+
+var largeIntPolicies = map[string]LargeIntPolicy{"send": LargeIntSend, "double": LargeIntDouble, "string": LargeIntString}
+
+// ParseLargeIntPolicy parses 'send', 'double' or 'string'
+func ParseLargeIntPolicy(s string) (LargeIntPolicy, error) {
+	p, ok := largeIntPolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown large integer policy '%s', try 'send', 'double' or 'string'", s)
+	}
+	return p, nil
+}
+
+// buildOptions controls how snapshots are turned into fields
+type buildOptions struct {
+	percentiles []float64
+	pnames      []string
+	workers     int
+	nan         NaNPolicy
+	large       LargeIntPolicy
+	types       FieldTypePolicy
+	// overrides replace the percentiles of matching metrics, the first
+	// match wins
+	overrides []percentileOverride
+	// sorted orders fields by metric name rather than registry order
+	sorted bool
+	// multi sends a single field of values per histogram, meter and timer
+	multi bool
+	// counters says how counters are sent
+	counters CounterMode
+	// meterDeltas adds the increase in each meter's count since the previous
+	// flush
+	meterDeltas bool
+	// gaugeRates are the patterns of gauges sent with deltas and rates
+	gaugeRates []string
+	// scheme says how the parts of field names are joined
+	scheme NameScheme
+	// precision rounds float values
+	precision floatPrecision
+	// maxName shortens longer field names, see WithMaxNameLength
+	maxName int
+	// invalidNames says what to do with invalid names, invalid counts them,
+	// updated atomically
+	invalidNames InvalidNamePolicy
+	invalid      int64
+
+	// nonFinite counts NaN and infinite values seen, updated atomically
+	nonFinite int64
+}
+
+func newBuildOptions() buildOptions {
+	return buildOptions{
+		percentiles: DefaultPercentiles,
+		pnames:      percentileNames(DefaultPercentiles),
+	}
+}
+
+// MakeMessage builds the message a HekaClient configured with opts would
+// send for r, header and static fields included, without connecting
+// anywhere, for applications carrying messages over their own transport
+//
+// options about connecting and sending have no effect, the 'Type' is empty
+// unless set with WithType
+func MakeMessage(r metrics.Registry, opts ...Option) (*message.Message, error) {
+	hc := newClient("")
+	for _, opt := range opts {
+		if err := opt(hc); err != nil {
+			return nil, err
+		}
+	}
+	return hc.message(r, hc.clock.Now().UnixNano())
+}
+
+// make_message stores every metric in r as fields of a new message
+func make_message(r metrics.Registry, o *buildOptions) *message.Message {
+	return build_message(snapshot(r), o)
+}
+
+// countFields returns how many integer and double fields build_message adds
+// for samples
+func countFields(samples []sample, o *buildOptions) (ints, floats int) {
+	multi := o.multi
+	for _, s := range samples {
+		nps := len(o.percentiles)
+		if len(o.overrides) > 0 {
+			ps, _ := o.percentilesFor(s.name)
+			nps = len(ps)
+		}
+		if s.stale {
+			ints++
+		}
+		switch s.metric.(type) {
+		case metrics.Counter:
+			ints++
+			if o.counters == CounterTotalAndDelta {
+				ints++
+			}
+		case metrics.Gauge:
+			ints++
+		case metrics.GaugeFloat64:
+			floats++
+		case metrics.Histogram:
+			if multi {
+				floats++
+				continue
+			}
+			ints += 3
+			floats += nps + len(histogramStats)
+		case metrics.Meter:
+			if o.meterDeltas {
+				ints++
+			}
+			if multi {
+				floats++
+				continue
+			}
+			ints++
+			floats += len(meterStats)
+		case metrics.Timer:
+			if multi {
+				floats++
+				continue
+			}
+			ints += 3
+			floats += nps + len(timerStats)
+		}
+	}
+	return
+}
+
+// parallelMinSamples is the fewest samples build_message shards across
+// workers, below it the goroutines cost more than they save
+const parallelMinSamples = 1024
+
+// build_message stores the snapshotted metrics as fields of a new message
+//
+// with more than one worker, large snapshots are split into contiguous shards
+// built concurrently and merged back in order
+func build_message(samples []sample, o *buildOptions) *message.Message {
+	if o.sorted {
+		sort.Sort(byName(samples))
+	}
+	workers := o.workers
+	if workers <= 1 || len(samples) < parallelMinSamples {
+		return &message.Message{Fields: build_fields(samples, o)}
+	}
+
+	shards := make([][]*message.Field, workers)
+	size := (len(samples) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for i := range shards {
+		lo, hi := i*size, (i+1)*size
+		if hi > len(samples) {
+			hi = len(samples)
+		}
+		if lo >= hi {
+			break
+		}
+		wg.Add(1)
+		go func(i int, shard []sample) {
+			defer wg.Done()
+			shards[i] = build_fields(shard, o)
+		}(i, samples[lo:hi])
+	}
+	wg.Wait()
+
+	n := 0
+	for _, fields := range shards {
+		n += len(fields)
+	}
+	msg := &message.Message{Fields: make([]*message.Field, 0, n)}
+	for _, fields := range shards {
+		msg.Fields = append(msg.Fields, fields...)
+	}
+	return msg
+}
+
+// build_fields turns samples into fields
+//
+// the fields are counted first so that the returned slice and the field
+// storage are each allocated once at their final size
+func build_fields(samples []sample, o *buildOptions) []*message.Field {
+
+	ints, floats := countFields(samples, o)
+	b := fieldBuilder{list: make([]*message.Field, 0, ints+floats), nan: o.nan, large: o.large, scheme: o.scheme, precision: o.precision, maxName: o.maxName}
+	b.grow(ints, floats)
+	defer func() {
+		if b.nonFinite > 0 {
+			atomic.AddInt64(&o.nonFinite, b.nonFinite)
+		}
+	}()
+
+	for _, s := range samples {
+		name := s.name
+		if !validName(name) {
+			atomic.AddInt64(&o.invalid, 1)
+			var ok bool
+			if name, ok = cleanName(name, o.invalidNames); !ok {
+				continue
+			}
+		}
+		ps, pnames := o.percentiles, o.pnames
+		if len(o.overrides) > 0 {
+			ps, pnames = o.percentilesFor(name)
+		}
+		if s.stale {
+			b.as = FieldNative
+			b.addInt(name, ".", "stale", 1)
+		}
+
+		switch metric := s.metric.(type) {
+		case metrics.Counter:
+			b.as = o.types.Counters
+			switch o.counters {
+			case CounterTotal:
+				b.addInt(name, "", "", metric.Count())
+			case CounterTotalAndDelta:
+				b.addInt(name, "", "", metric.Count())
+				b.addInt(name, ".", "delta", delta(s.prev, metric.Count()))
+			case CounterDelta:
+				b.addInt(name, "", "", delta(s.prev, metric.Count()))
+			}
+		case metrics.Gauge:
+			b.as = o.types.Gauges
+			b.addInt(name, "", "", metric.Value())
+			if w := s.window; w != nil {
+				b.addInt(name, ".", "min", int64(w.min))
+				b.addInt(name, ".", "max", int64(w.max))
+				b.addFloat(name, ".", "avg", w.sum/float64(w.n))
+			}
+			if s.since > 0 && matchAny(o.gaugeRates, name) {
+				d := metric.Value() - s.prev
+				b.addInt(name, ".", "delta", d)
+				b.addFloat(name, ".", "rate", float64(d)/s.since)
+			}
+		case metrics.GaugeFloat64:
+			b.as = o.types.GaugeFloats
+			b.addFloat(name, "", "", metric.Value())
+			if w := s.window; w != nil {
+				b.addFloat(name, ".", "min", w.min)
+				b.addFloat(name, ".", "max", w.max)
+				b.addFloat(name, ".", "avg", w.sum/float64(w.n))
+			}
+			if s.since > 0 && matchAny(o.gaugeRates, name) {
+				d := metric.Value() - s.prevFloat
+				b.addFloat(name, ".", "delta", d)
+				b.addFloat(name, ".", "rate", d/s.since)
+			}
+
+		case metrics.Histogram:
+			if o.multi {
+				b.addValues(name, ".histogram", append(metric.Percentiles(ps),
+					metric.Mean(), metric.StdDev(), float64(metric.Count()),
+					float64(metric.Min()), float64(metric.Max())))
+				continue
+			}
+			b.as = o.types.Histograms
+			b.addFloats(name, ".histogram.", pnames, metric.Percentiles(ps))
+			b.addFloats(name, ".histogram.", histogramStats,
+				[]float64{metric.Mean(), metric.StdDev()})
+			b.addInt(name, ".histogram.", "count", metric.Count())
+			b.addInt(name, ".histogram.", "min", metric.Min())
+			b.addInt(name, ".histogram.", "max", metric.Max())
+
+		case metrics.Meter:
+			b.as = o.types.Meters
+			if o.meterDeltas {
+				b.addInt(name, ".", "delta", delta(s.prev, metric.Count()))
+			}
+			if o.multi {
+				b.addValues(name, ".meter", []float64{float64(metric.Count()),
+					metric.Rate1(), metric.Rate5(), metric.Rate15(), metric.RateMean()})
+				continue
+			}
+			b.addInt(name, ".", "count", metric.Count())
+			b.addFloats(name, ".", meterStats, []float64{metric.Rate1(),
+				metric.Rate5(), metric.Rate15(), metric.RateMean()})
+
+		case metrics.Timer:
+			if o.multi {
+				b.addValues(name, ".timer", append(metric.Percentiles(ps),
+					metric.Mean(), metric.StdDev(), metric.Rate1(), metric.Rate5(),
+					metric.Rate15(), metric.RateMean(), float64(metric.Count()),
+					float64(metric.Min()), float64(metric.Max())))
+				continue
+			}
+			b.as = o.types.Timers
+			b.addFloats(name, ".timer.", pnames, metric.Percentiles(ps))
+			b.addFloats(name, ".timer.", timerStats, []float64{metric.Mean(),
+				metric.StdDev(), metric.Rate1(), metric.Rate5(), metric.Rate15(),
+				metric.RateMean()})
+			b.addInt(name, ".timer.", "count", metric.Count())
+			b.addInt(name, ".timer.", "min", metric.Min())
+			b.addInt(name, ".timer.", "max", metric.Max())
+		}
+	}
+	return b.list
+
+}
+
+// fieldBlock is how many fields, names and values fieldBuilder allocates at
+// a time once it runs out of the storage set up by grow
+const fieldBlock = 256
+
+// the value types and representation every built field points to
+var (
+	integerType      = message.Field_INTEGER
+	doubleType       = message.Field_DOUBLE
+	stringType       = message.Field_STRING
+	noRepresentation = ""
+)
+
+// fieldBuilder appends fields to list, composing names in a reused buffer
+// and allocating fields and their values in blocks rather than one by one
+type fieldBuilder struct {
+	nan       NaNPolicy
+	nonFinite int64
+	large     LargeIntPolicy
+	scheme    NameScheme
+	precision floatPrecision
+	maxName   int
+	// as is the FieldType of the metric being added
+	as FieldType
+
+	list   []*message.Field
+	name   []byte
+	fields []message.Field
+	names  []string
+	ints   []int64
+	floats []float64
+}
+
+// grow allocates storage for ints integer and floats double fields
+func (b *fieldBuilder) grow(ints, floats int) {
+	b.fields = make([]message.Field, 0, ints+floats)
+	b.names = make([]string, 0, ints+floats)
+	b.ints = make([]int64, 0, ints)
+	b.floats = make([]float64, 0, floats)
+}
+
+// next appends a new field named prefix+sep+stat to list, with its dots
+// replaced as the NameScheme says and shortened to maxName
+func (b *fieldBuilder) next(prefix, sep, stat string) *message.Field {
+	if len(b.fields) == cap(b.fields) {
+		b.fields = make([]message.Field, 0, fieldBlock)
+		b.names = make([]string, 0, fieldBlock)
+	}
+	b.fields = b.fields[:len(b.fields)+1]
+	b.names = b.names[:len(b.names)+1]
+	name := &b.names[len(b.names)-1]
+	if b.scheme != NamesDotted {
+		b.name = append(append(append(b.name[:0], prefix...), sep...), stat...)
+		s := b.scheme.sep()[0]
+		for i, c := range b.name {
+			if c == '.' {
+				b.name[i] = s
+			}
+		}
+		*name = string(b.name)
+	} else if sep == "" && stat == "" {
+		*name = prefix
+	} else {
+		b.name = append(append(append(b.name[:0], prefix...), sep...), stat...)
+		*name = string(b.name)
+	}
+	if b.maxName > 0 && len(*name) > b.maxName {
+		*name = shorten(*name, b.maxName, b.scheme.sep())
+	}
+	f := &b.fields[len(b.fields)-1]
+	f.Name = name
+	f.Representation = &noRepresentation
+	b.list = append(b.list, f)
+	return f
+}
+
+func (b *fieldBuilder) addInt(prefix, sep, stat string, v int64) {
+	if b.as == FieldDouble {
+		b.addFloat(prefix, sep, stat, float64(v))
+		return
+	}
+	if b.large != LargeIntSend && (v > maxExactInt || v < -maxExactInt) {
+		if b.large == LargeIntDouble {
+			b.addFloat(prefix, sep, stat, float64(v))
+			return
+		}
+		f := b.next(prefix, sep, stat)
+		f.ValueType = &stringType
+		f.ValueString = []string{strconv.FormatInt(v, 10)}
+		return
+	}
+	f := b.next(prefix, sep, stat)
+	f.ValueType = &integerType
+	if len(b.ints) == cap(b.ints) {
+		b.ints = make([]int64, 0, fieldBlock)
+	}
+	b.ints = append(b.ints, v)
+	n := len(b.ints)
+	f.ValueInteger = b.ints[n-1 : n : n]
+}
+
+func (b *fieldBuilder) addFloat(prefix, sep, stat string, v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		b.nonFinite++
+		switch b.nan {
+		case NaNSkip:
+			return
+		case NaNZero:
+			v = 0
+		}
+	} else if v = b.precision.round(v); b.as == FieldInteger && v >= math.MinInt64 && v < math.MaxInt64 {
+		b.addInt(prefix, sep, stat, int64(math.Floor(v+0.5)))
+		return
+	}
+	f := b.next(prefix, sep, stat)
+	f.ValueType = &doubleType
+	if len(b.floats) == cap(b.floats) {
+		b.floats = make([]float64, 0, fieldBlock)
+	}
+	b.floats = append(b.floats, v)
+	n := len(b.floats)
+	f.ValueDouble = b.floats[n-1 : n : n]
+}
+
+// addValues adds a single double field named prefix+kind holding vals,
+// NaN and infinite values are zeroed with NaNZero and otherwise sent, since
+// leaving them out would shift the ones after them
+func (b *fieldBuilder) addValues(prefix, kind string, vals []float64) {
+	for i, v := range vals {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			b.nonFinite++
+			if b.nan == NaNZero {
+				vals[i] = 0
+			}
+		} else {
+			vals[i] = b.precision.round(v)
+		}
+	}
+	f := b.next(prefix, kind, "")
+	f.ValueType = &doubleType
+	f.ValueDouble = vals
+}
+
+// addFloats adds a field per stat with the value at the same index in vals
+func (b *fieldBuilder) addFloats(prefix, sep string, stats []string, vals []float64) {
+	for i, stat := range stats {
+		if i >= len(vals) {
+			logger.Printf("skipping: %s%s%s no value\n", prefix, sep, stat)
+			continue
+		}
+		b.addFloat(prefix, sep, stat, vals[i])
+	}
+}
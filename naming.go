@@ -0,0 +1,166 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NameScheme says how the parts of a metric field's name are joined, the
+// dots of hierarchical metric names like 'api.latency' included
+type NameScheme int
+
+const (
+	// NamesDotted joins them with dots, 'api.latency.timer.count', the
+	// default
+	NamesDotted NameScheme = iota
+	// NamesUnderscore joins them with underscores, 'api_latency_timer_count',
+	// for stores like Elasticsearch that map dots to nested objects
+	NamesUnderscore
+)
+
+var nameSchemes = map[string]NameScheme{"dotted": NamesDotted, "underscore": NamesUnderscore}
+
+// ParseNameScheme parses 'dotted' or 'underscore'
+func ParseNameScheme(s string) (NameScheme, error) {
+	n, ok := nameSchemes[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown name scheme '%s', try 'dotted' or 'underscore'", s)
+	}
+	return n, nil
+}
+
+// sep returns the separator of the scheme
+func (n NameScheme) sep() string {
+	if n == NamesUnderscore {
+		return "_"
+	}
+	return "."
+}
+
+// rename returns name with its dots replaced by the scheme's separator
+func (n NameScheme) rename(name string) string {
+	if n == NamesDotted {
+		return name
+	}
+	return strings.Replace(name, ".", n.sep(), -1)
+}
+
+// nameHashLen is the length of the hash shorten ends names with
+const nameHashLen = 8
+
+// shorten cuts name to max bytes, on a rune boundary, ending it with sep and
+// a hash of the whole name so that long names sharing a prefix stay apart
+// and a name is always shortened the same way
+func shorten(name string, max int, sep string) string {
+	if max <= 0 || len(name) <= max {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	cut := max - len(sep) - nameHashLen
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s%s%08x", strings.TrimRight(name[:cut], sep), sep, h.Sum32())
+}
+
+// InvalidNamePolicy says what to do with metric names holding invalid UTF-8
+// or control characters, which break protobuf string validation and JSON
+// decoders downstream, or come out of them mangled
+type InvalidNamePolicy int
+
+const (
+	// InvalidNameSend sends the names as they are, the default
+	InvalidNameSend InvalidNamePolicy = iota
+	// InvalidNameReplace replaces each invalid byte and control character
+	// with an underscore
+	InvalidNameReplace
+	// InvalidNameEscape replaces invalid bytes with '\xNN' and control
+	// characters with '\uNNNN', keeping names apart that would collide once
+	// replaced
+	InvalidNameEscape
+	// InvalidNameSkip leaves the metric out of the message
+	InvalidNameSkip
+)
+
+var invalidNamePolicies = map[string]InvalidNamePolicy{
+	"send": InvalidNameSend, "replace": InvalidNameReplace, "escape": InvalidNameEscape, "skip": InvalidNameSkip,
+}
+
+// ParseInvalidNamePolicy parses 'send', 'replace', 'escape' or 'skip'
+func ParseInvalidNamePolicy(s string) (InvalidNamePolicy, error) {
+	p, ok := invalidNamePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown invalid name policy '%s', try 'send', 'replace', 'escape' or 'skip'", s)
+	}
+	return p, nil
+}
+
+// validName reports whether name is valid UTF-8 without control characters
+func validName(name string) bool {
+	return utf8.ValidString(name) && strings.IndexFunc(name, unicode.IsControl) < 0
+}
+
+// cleanName returns the invalid name as p says, and false if it's skipped
+func cleanName(name string, p InvalidNamePolicy) (string, bool) {
+	switch p {
+	case InvalidNameSend:
+		return name, true
+	case InvalidNameSkip:
+		return "", false
+	}
+	b := make([]byte, 0, len(name)+8)
+	for i := 0; i < len(name); {
+		r, n := utf8.DecodeRuneInString(name[i:])
+		switch {
+		case r == utf8.RuneError && n == 1:
+			if p == InvalidNameEscape {
+				b = append(b, fmt.Sprintf("\\x%02x", name[i])...)
+			} else {
+				b = append(b, '_')
+			}
+		case unicode.IsControl(r):
+			if p == InvalidNameEscape {
+				b = append(b, fmt.Sprintf("\\u%04x", r)...)
+			} else {
+				b = append(b, '_')
+			}
+		default:
+			b = append(b, name[i:i+n]...)
+		}
+		i += n
+	}
+	return string(b), true
+}
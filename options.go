@@ -0,0 +1,1082 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Option configures optional behavior of a HekaClient, see NewHekaClient
+type Option func(*HekaClient) error
+
+// WithLogSuppression sets the window over which repeats of the same log line
+// are collapsed into a single "repeated N times" summary
+//
+// d <= 0 disables suppression and logs every occurrence, the default is
+// DefaultLogSuppression
+func WithLogSuppression(d time.Duration) Option {
+	return func(hc *HekaClient) error {
+		hc.log.window = d
+		return nil
+	}
+}
+
+// WithInterval sets the flush interval LogHeka uses when it's passed a zero
+// Duration, the default is DefaultInterval
+func WithInterval(d time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if d <= 0 {
+			return fmt.Errorf("interval: must be positive, got %s", d)
+		}
+		hc.interval = d
+		return nil
+	}
+}
+
+// WithWriteTimeout bounds how long connecting to Heka and each send may
+// block, 0 (the default) means no timeout
+func WithWriteTimeout(d time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if d < 0 {
+			return fmt.Errorf("write_timeout: must not be negative, got %s", d)
+		}
+		hc.timeout = d
+		return nil
+	}
+}
+
+// WithSeverity sets the 'Severity' field on messages, the default is
+// DefaultSeverity
+func WithSeverity(severity int32) Option {
+	return func(hc *HekaClient) error {
+		hc.severity = severity
+		return nil
+	}
+}
+
+// WithSeverityFunc has f choose the 'Severity' of each message after its
+// fields are built, see SeverityFunc
+func WithSeverityFunc(f SeverityFunc) Option {
+	return func(hc *HekaClient) error {
+		hc.severityFunc = f
+		return nil
+	}
+}
+
+// WithInclude limits the metrics sent to those whose names match one of
+// patterns, see path.Match for the pattern syntax
+func WithInclude(patterns ...string) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns(patterns); err != nil {
+			return fmt.Errorf("include: %s", err)
+		}
+		hc.filter.include = append(hc.filter.include, patterns...)
+		return nil
+	}
+}
+
+// WithExclude drops metrics whose names match one of patterns, excludes win
+// over includes
+func WithExclude(patterns ...string) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns(patterns); err != nil {
+			return fmt.Errorf("exclude: %s", err)
+		}
+		hc.filter.exclude = append(hc.filter.exclude, patterns...)
+		return nil
+	}
+}
+
+// WithField adds a static field to every message, value may be any type
+// message.NewField accepts
+func WithField(name string, value interface{}) Option {
+	return func(hc *HekaClient) error {
+		f, err := message.NewField(name, value, "")
+		if err != nil {
+			return fmt.Errorf("field %s: %s", name, err)
+		}
+		hc.fields = append(hc.fields, f)
+		return nil
+	}
+}
+
+// WithPercentiles sets the percentiles sent for histograms and timers, each
+// must be between 0 and 1, the default is DefaultPercentiles
+func WithPercentiles(ps ...float64) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPercentiles(ps); err != nil {
+			return fmt.Errorf("percentiles: %s", err)
+		}
+		hc.build.percentiles = append([]float64(nil), ps...)
+		hc.build.pnames = percentileNames(ps)
+		return nil
+	}
+}
+
+// WithPercentileOverride sends ps instead of the client's percentiles for
+// histograms and timers whose names match pattern, e.g. high resolution
+// percentiles only for 'api.*'
+//
+// overrides are tried in the order they're added and the first match wins
+func WithPercentileOverride(pattern string, ps ...float64) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns([]string{pattern}); err != nil {
+			return fmt.Errorf("percentile_overrides: %s", err)
+		}
+		if err := checkPercentiles(ps); err != nil {
+			return fmt.Errorf("percentile_overrides: %s: %s", pattern, err)
+		}
+		hc.build.overrides = append(hc.build.overrides, percentileOverride{
+			pattern: pattern,
+			ps:      append([]float64(nil), ps...),
+			pnames:  percentileNames(ps),
+		})
+		return nil
+	}
+}
+
+// WithFailureWatchdog calls f once n flushes in a row failed to send, so
+// operators hear about metrics being lost while Heka is unreachable, it's
+// called again after a flush succeeds and n more fail
+//
+// only the client's own output is watched, not its sinks
+func WithFailureWatchdog(n int, f FailureFunc) Option {
+	return func(hc *HekaClient) error {
+		if n < 1 {
+			return fmt.Errorf("failure_watchdog: must be positive, got %d", n)
+		}
+		if f == nil {
+			return fmt.Errorf("failure_watchdog: nil func")
+		}
+		hc.watchdog.notifyAt = n
+		hc.watchdog.notify = f
+		return nil
+	}
+}
+
+// WithFailureFile appends the messages of failing flushes to the file at
+// path as a Heka stream, once n flushes in a row failed to send, for a Heka
+// LogstreamerInput to pick up later
+//
+// with schemes sending messages one at a time, those sent before the
+// failure are written too
+func WithFailureFile(path string, n int) Option {
+	return func(hc *HekaClient) error {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("failure_file: must be an absolute path, got '%s'", path)
+		}
+		if n < 1 {
+			return fmt.Errorf("failure_threshold: must be positive, got %d", n)
+		}
+		hc.watchdog.path = path
+		hc.watchdog.spillAt = n
+		return nil
+	}
+}
+
+// WithClockOffset adds d to the Timestamp and CaptureField of every
+// message, for hosts whose clock is known to be off by -d, feeding Heka
+// filters that compare times across hosts
+func WithClockOffset(d time.Duration) Option {
+	return func(hc *HekaClient) error {
+		hc.offset = d
+		return nil
+	}
+}
+
+// WithClockOffsetFunc is WithClockOffset with the offset asked of f at
+// every flush, e.g. an NTP client's current estimate
+//
+// f is called from the flushing goroutine and must not block
+func WithClockOffsetFunc(f func() time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if f == nil {
+			return fmt.Errorf("clock_offset: nil func")
+		}
+		hc.offsetFunc = f
+		return nil
+	}
+}
+
+// WithHeartbeat sends a message of Type HeartbeatType, with no metrics,
+// with every flush, so consumers detecting absent hosts can tell a host
+// that's down from one whose filters left nothing to report
+//
+// messages that would hold no metrics aren't sent, the heartbeat stands
+// in for them
+func WithHeartbeat() Option {
+	return func(hc *HekaClient) error {
+		hc.heartbeat = true
+		return nil
+	}
+}
+
+// WithUDPFallback has a 'tcp://' client send over udp, best effort, once
+// it has failed to connect for d, trying tcp again every d until it's
+// back, so some metrics still arrive during partial outages
+//
+// addr is the 'host:port' to send to, the tcp address when empty, and Heka
+// needs a UdpInput listening there
+func WithUDPFallback(d time.Duration, addr string) Option {
+	return func(hc *HekaClient) error {
+		if d <= 0 {
+			return fmt.Errorf("udp_fallback: must be positive, got %s", d)
+		}
+		if hc.connect_s == nil || hc.connect_s.Scheme != "tcp" {
+			return fmt.Errorf("udp_fallback: only 'tcp://' clients can fall back")
+		}
+		if len(hc.rr.hosts) > 0 {
+			return fmt.Errorf("udp_fallback: clients with several hosts can't fall back")
+		}
+		if addr != "" {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("udp_fallback_addr: %s", err)
+			}
+		}
+		hc.fallback = udpFallback{after: d, addr: addr}
+		return nil
+	}
+}
+
+// WithReconnectDelay waits at least min after a failed connection attempt
+// before dialing again, doubling the wait with each failure up to max, 0
+// for no limit, instead of dialing with every flush
+//
+// flushes while waiting drop their messages with ErrReconnectDelay, and a
+// send failing on an established connection still reconnects right away
+func WithReconnectDelay(min, max time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if min <= 0 {
+			return fmt.Errorf("reconnect_min_delay: must be positive, got %s", min)
+		}
+		if max != 0 && max < min {
+			return fmt.Errorf("reconnect_max_delay: must not be less than reconnect_min_delay, got %s", max)
+		}
+		hc.backoff.min, hc.backoff.max = min, max
+		return nil
+	}
+}
+
+// WithEagerReconnect dials again as soon as the delay set with
+// WithReconnectDelay is up, in the background, so the next flush finds a
+// connection, rather than when the next flush needs one
+func WithEagerReconnect() Option {
+	return func(hc *HekaClient) error {
+		hc.backoff.eager = true
+		return nil
+	}
+}
+
+// WithDialer makes the client's connections with d, e.g. to pick the
+// source address or turn off dual-stack fallback, the write timeout still
+// bounds each dial
+//
+// the amqp, nats and zmq outputs dial with their own libraries and ignore
+// it
+func WithDialer(d *net.Dialer) Option {
+	return func(hc *HekaClient) error {
+		if d == nil {
+			return fmt.Errorf("dialer: nil dialer")
+		}
+		hc.dialFunc = d.DialContext
+		return nil
+	}
+}
+
+// WithDialFunc makes the client's connections with f, e.g. to go through a
+// proxy or a custom network layer, see WithDialer
+func WithDialFunc(f DialFunc) Option {
+	return func(hc *HekaClient) error {
+		if f == nil {
+			return fmt.Errorf("dialer: nil func")
+		}
+		hc.dialFunc = f
+		return nil
+	}
+}
+
+// WithEagerConnect makes NewHekaClient dial Heka immediately and return the
+// connection error, instead of connecting lazily on the first flush
+//
+// with udp this only catches address resolution errors, nothing is sent
+func WithEagerConnect() Option {
+	return func(hc *HekaClient) error {
+		hc.eager = true
+		return nil
+	}
+}
+
+// WithType sets the 'Type' field, overriding the msgtype given to
+// NewHekaClient
+func WithType(msgtype string) Option {
+	return func(hc *HekaClient) error {
+		hc.msgtype = msgtype
+		return nil
+	}
+}
+
+// WithHostname overrides the 'Hostname' field, which otherwise is
+// os.Hostname(), e.g. with an externally visible FQDN or a pod name
+func WithHostname(hostname string) Option {
+	return func(hc *HekaClient) error {
+		if hostname == "" {
+			return fmt.Errorf("hostname: must not be empty")
+		}
+		hc.hostname = hostname
+		return nil
+	}
+}
+
+// WithPid overrides the 'Pid' header, which otherwise is os.Getpid(), e.g.
+// with a stable worker ID in containers where every process is pid 1
+func WithPid(pid int32) Option {
+	return func(hc *HekaClient) error {
+		hc.pid = pid
+		hc.omitPid = false
+		return nil
+	}
+}
+
+// WithoutPid leaves the 'Pid' header unset
+func WithoutPid() Option {
+	return func(hc *HekaClient) error {
+		hc.omitPid = true
+		return nil
+	}
+}
+
+// WithEnvVersion sets the 'EnvVersion' header, for sites whose message
+// schemas require it
+//
+// it defaults to the HEKAMETRICS_ENV_VERSION environment variable, or is
+// left unset, so a fleet wide schema version can be rolled out by
+// deployment configuration without code changes
+func WithEnvVersion(v string) Option {
+	return func(hc *HekaClient) error {
+		hc.envVersion = v
+		return nil
+	}
+}
+
+// WithLogger overrides the 'Logger' header, DefaultLogger unless set
+func WithLogger(name string) Option {
+	return func(hc *HekaClient) error {
+		if name == "" {
+			return fmt.Errorf("logger: must not be empty")
+		}
+		hc.logname = name
+		return nil
+	}
+}
+
+// WithCounterMode sets whether counters are sent as totals, the default,
+// as deltas since the previous flush, or both, see CounterMode
+//
+// deltas are tracked by flush, MakeMessage and the Prometheus handler
+// report the whole count
+func WithCounterMode(m CounterMode) Option {
+	return func(hc *HekaClient) error {
+		hc.build.counters = m
+		return nil
+	}
+}
+
+// WithBackpressure calls f once the messages built by flushes and waiting
+// to be sent, to the client or its sinks, reach high, and again once
+// they're down to low, so the application can shed load or complain when
+// telemetry backs up
+//
+// flushes send synchronously, messages wait while a flush is sending and
+// others, from Flush or LogHeka, are waiting for it to finish
+func WithBackpressure(high, low int, f BackpressureFunc) Option {
+	return func(hc *HekaClient) error {
+		if low < 0 || high <= low {
+			return fmt.Errorf("backpressure: want 0 <= low < high, got low %d and high %d", low, high)
+		}
+		if f == nil {
+			return fmt.Errorf("backpressure: nil func")
+		}
+		hc.backpressure.high = high
+		hc.backpressure.low = low
+		hc.backpressure.f = f
+		return nil
+	}
+}
+
+// WithSampleRate sends the metrics matching pattern only with every nth
+// flush, starting with the first, to cut the volume of noisy or slow moving
+// metrics while still sending them now and then
+//
+// counter and meter deltas, gauge rates and gauge sampling windows cover
+// every interval since the metric was last sent, rates are tried in the
+// order they're added and the first match wins
+func WithSampleRate(pattern string, every int) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns([]string{pattern}); err != nil {
+			return fmt.Errorf("sample_rates: %s", err)
+		}
+		if every < 1 {
+			return fmt.Errorf("sample_rates: %s: every must be positive, got %d", pattern, every)
+		}
+		hc.rates = append(hc.rates, sampleRate{pattern: pattern, every: int64(every)})
+		return nil
+	}
+}
+
+// WithSchema sends a message of Type SchemaType along with the metrics,
+// with a field per metric given to Describe holding its description, its
+// unit as the field's representation, so downstream consumers can document
+// the metrics they receive
+//
+// it's sent with every flush when every is 0, or with the first flush at
+// least every after the previous one
+func WithSchema(every time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if every < 0 {
+			return fmt.Errorf("schema_every: must not be negative, got %s", every)
+		}
+		hc.schema = true
+		hc.schemaEvery = every
+		return nil
+	}
+}
+
+// WithStaleMetrics treats metrics whose count, or a gauge's value, hasn't
+// changed for d as stale, flagging them with a '<name>.stale' field or
+// leaving them out of messages as policy says, and with unregister also
+// removes them from their registry, so dead metrics with dynamic names
+// don't grow messages forever
+//
+// metrics are checked at each flush, d is rounded up to the flush interval
+func WithStaleMetrics(d time.Duration, policy StalePolicy, unregister bool) Option {
+	return func(hc *HekaClient) error {
+		if d <= 0 {
+			return fmt.Errorf("stale_after: must be positive, got %s", d)
+		}
+		if policy < StaleFlag || policy > StaleOmit {
+			return fmt.Errorf("stale_policy: unknown policy %d", policy)
+		}
+		hc.stale.after = int64(d)
+		hc.stale.policy = policy
+		hc.stale.unregister = unregister
+		return nil
+	}
+}
+
+// WithTopN sends only the n largest counters, by count, and the n largest
+// timers, by mean duration, of each flush, along with every other metric,
+// for registries with too many metrics to send them all
+//
+// metrics matching one of the always patterns are sent regardless and
+// don't count towards n, with CounterDelta counters are ranked by delta
+func WithTopN(n int, always ...string) Option {
+	return func(hc *HekaClient) error {
+		if n < 0 {
+			return fmt.Errorf("top_n: must not be negative, got %d", n)
+		}
+		if err := checkPatterns(always); err != nil {
+			return fmt.Errorf("top_n_always: %s", err)
+		}
+		hc.topN = topN{n: n, always: append([]string(nil), always...)}
+		return nil
+	}
+}
+
+// WithMeterDeltas adds a '<name>.delta' field to meters with the increase
+// in their count since the previous flush, next to the cumulative count, so
+// dashboards can plot events per interval directly
+func WithMeterDeltas() Option {
+	return func(hc *HekaClient) error {
+		hc.build.meterDeltas = true
+		return nil
+	}
+}
+
+// WithGaugeRates adds '<name>.delta' and '<name>.rate' fields to the gauges
+// whose names match one of patterns, with the change since the previous
+// flush and that change per second, for gauges that really are running
+// totals kept elsewhere
+//
+// they're left out on the first flush, having nothing to compare with
+func WithGaugeRates(patterns ...string) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns(patterns); err != nil {
+			return fmt.Errorf("gauge_rates: %s", err)
+		}
+		hc.build.gaugeRates = append(hc.build.gaugeRates, patterns...)
+		return nil
+	}
+}
+
+// WithGaugeSampling has LogHeka read gauges every d between flushes, and
+// adds '<name>.min', '<name>.max' and '<name>.avg' fields with their
+// minimum, maximum and average since the previous flush, so gauges can be
+// watched closely while messages are sent far less often
+//
+// when d isn't shorter than the flush interval gauges are only read by the
+// flushes, and the fields repeat their value
+func WithGaugeSampling(d time.Duration) Option {
+	return func(hc *HekaClient) error {
+		if d < 0 {
+			return fmt.Errorf("gauge_sampling: must not be negative, got %s", d)
+		}
+		hc.sampleEvery = d
+		return nil
+	}
+}
+
+// WithMultiValueFields sends each histogram, meter and timer as a single
+// double field, named after the metric and its kind, whose values are its
+// statistics in a fixed order, instead of a field per statistic:
+//
+//	name.histogram: percentiles..., mean, std-dev, count, min, max
+//	name.meter: count, one-minute, five-minute, fifteen-minute, mean
+//	name.timer: percentiles..., mean, std-dev, one-minute, five-minute,
+//	            fifteen-minute, mean-rate, count, min, max
+//
+// the percentiles are those set with WithPercentiles, in order, counters and
+// gauges are sent as before and the FieldTypePolicy doesn't apply
+//
+// only the Heka protobuf schemes send every value of a field, creating a
+// client, or one with a sink, for another scheme fails
+func WithMultiValueFields() Option {
+	return func(hc *HekaClient) error {
+		hc.build.multi = true
+		return nil
+	}
+}
+
+// WithHybridPayload puts all the metrics of a message in its payload, as a
+// compact JSON object of field names and values, and keeps only the fields
+// whose names match one of the keep patterns, plus the static ones, for
+// pipelines that archive payloads but route on a few key fields
+//
+// with WithMultiValueFields a field's values are a JSON array in the same
+// order
+//
+// it's ignored with WithStatmetric, and rejected for schemes that only
+// send fields, like graphite, influx, statsd, collectd and text
+func WithHybridPayload(keep ...string) Option {
+	return func(hc *HekaClient) error {
+		if err := checkPatterns(keep); err != nil {
+			return fmt.Errorf("hybrid_fields: %s", err)
+		}
+		hc.hybrid = append([]string{}, keep...)
+		return nil
+	}
+}
+
+// WithTreePayload is WithHybridPayload with the JSON object nested by the
+// dotted parts of the field names, {"api": {"latency": {"timer": {...}}}},
+// for Lua filters walking the metric tree rather than hundreds of flat keys
+//
+// a value whose name prefixes others, like a counter 'api' next to a timer
+// 'api.latency', is nested under TreeValue, names are only split on dots so
+// the object is flat with NamesUnderscore
+func WithTreePayload(keep ...string) Option {
+	return func(hc *HekaClient) error {
+		if err := WithHybridPayload(keep...)(hc); err != nil {
+			return err
+		}
+		hc.tree = true
+		return nil
+	}
+}
+
+// WithSequence adds a SequenceField to every message, counting the client's
+// flushes from 1, so consumers can spot dropped or reordered flushes from a
+// host; the messages of a single flush share the same number
+func WithSequence() Option {
+	return func(hc *HekaClient) error {
+		hc.sequence = true
+		return nil
+	}
+}
+
+// WithCaptureField adds a CaptureField holding the exact time the
+// registries were read, which the Timestamp may not be with
+// WithTimestamp(IntervalStart), and which survives delays spooling or
+// retrying the message
+func WithCaptureField() Option {
+	return func(hc *HekaClient) error {
+		hc.captureField = true
+		return nil
+	}
+}
+
+// WithStatmetric sends messages shaped like those of Heka's StatAccumInput,
+// Type 'heka.statmetric' with the metrics as graphite lines in the payload,
+// so they drop straight into existing statmetric filters and CarbonOutputs
+//
+// the type passed to NewHekaClient is ignored, and like WithHybridPayload
+// it's rejected for schemes that only send fields
+func WithStatmetric() Option {
+	return func(hc *HekaClient) error {
+		hc.statmetric = true
+		return nil
+	}
+}
+
+// WithRegistries adds registries that are flushed along with the one passed
+// to LogHeka or Flush, each as its own message
+//
+// on tcp the messages of a flush are coalesced into a single write
+func WithRegistries(rs ...metrics.Registry) Option {
+	return func(hc *HekaClient) error {
+		for _, r := range rs {
+			hc.registries = append(hc.registries, namedRegistry{r, ""})
+		}
+		return nil
+	}
+}
+
+// WithNamedRegistry adds a registry like WithRegistries whose messages carry
+// logger as their 'Logger', e.g. the name of the subsystem it belongs to,
+// so Heka matchers can route them on their own
+func WithNamedRegistry(logger string, r metrics.Registry) Option {
+	return func(hc *HekaClient) error {
+		if logger == "" {
+			return fmt.Errorf("logger: must not be empty")
+		}
+		hc.registries = append(hc.registries, namedRegistry{r, logger})
+		return nil
+	}
+}
+
+// WithRateLimit caps outbound traffic at bytesPerSecond, delaying sends as
+// needed, so a huge registry or a reconnect burst can't saturate a narrow link
+func WithRateLimit(bytesPerSecond int) Option {
+	return func(hc *HekaClient) error {
+		if bytesPerSecond <= 0 {
+			return fmt.Errorf("rate_limit: must be positive, got %d", bytesPerSecond)
+		}
+		hc.limiter = newByteLimiter(bytesPerSecond)
+		return nil
+	}
+}
+
+// WithParallelBuild shards building the fields of registries with thousands
+// of metrics across workers goroutines, keeping flush latency bounded for
+// very large registries
+func WithParallelBuild(workers int) Option {
+	return func(hc *HekaClient) error {
+		if workers < 1 {
+			return fmt.Errorf("parallel_build: must be at least 1, got %d", workers)
+		}
+		hc.build.workers = workers
+		return nil
+	}
+}
+
+// WithNaNPolicy sets what happens to NaN and infinite values, the default is
+// NaNSend, occurrences are counted in Stats either way
+func WithNaNPolicy(p NaNPolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < NaNSend || p > NaNZero {
+			return fmt.Errorf("nan_policy: unknown policy %d", p)
+		}
+		hc.build.nan = p
+		return nil
+	}
+}
+
+// WithTimestamp sets whether messages are stamped with the time their
+// registry was read, IntervalEnd and the default, or with the time of the
+// previous read, IntervalStart
+func WithTimestamp(m TimestampMode) Option {
+	return func(hc *HekaClient) error {
+		if m != IntervalEnd && m != IntervalStart {
+			return fmt.Errorf("timestamp: unknown mode %d", m)
+		}
+		hc.timestamp = m
+		return nil
+	}
+}
+
+// WithUUID sets the function generating message Uuids, replacing
+// NewRandomUUID, e.g. to make them deterministic in tests
+func WithUUID(f UUIDFunc) Option {
+	return func(hc *HekaClient) error {
+		if f == nil {
+			return fmt.Errorf("uuid: nil UUIDFunc")
+		}
+		hc.uuid = f
+		return nil
+	}
+}
+
+// WithNameUUIDs derives each message's Uuid, a version 5 UUID in
+// NameUUIDNamespace, from the hostname, the Timestamp and a count of the
+// messages the client built, instead of calling the UUIDFunc, so a
+// retransmitted or replayed message can be recognized downstream
+func WithNameUUIDs() Option {
+	return func(hc *HekaClient) error {
+		hc.nameUUIDs = true
+		return nil
+	}
+}
+
+// WithEncodeErrorPolicy sets what a flush does when a message fails to
+// encode, the default is EncodeSkip
+func WithEncodeErrorPolicy(p EncodeErrorPolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < EncodeSkip || p > EncodeHandler {
+			return fmt.Errorf("on_encode_error: unknown policy %d", p)
+		}
+		hc.onEncodeError = p
+		return nil
+	}
+}
+
+// WithEncodeErrorHandler calls f with each message that fails to encode and
+// the error, and sets the EncodeHandler policy so the rest of the flush is
+// still sent
+//
+// f is called with the client's send lock held, so it must not call Flush
+func WithEncodeErrorHandler(f func(msg *message.Message, err error)) Option {
+	return func(hc *HekaClient) error {
+		if f == nil {
+			return fmt.Errorf("on_encode_error: nil handler")
+		}
+		hc.onEncodeError = EncodeHandler
+		hc.encodeHandler = f
+		return nil
+	}
+}
+
+// WithMaxMessageSize sets the largest encoded Heka message sent, bigger
+// messages are handled by the OversizePolicy
+//
+// the default is DefaultMaxMessageSize, 0 means no limit beyond the
+// datagram size on udp
+func WithMaxMessageSize(n int) Option {
+	return func(hc *HekaClient) error {
+		if n < 0 {
+			return fmt.Errorf("max_message_size: must not be negative, got %d", n)
+		}
+		hc.maxMessageSize = n
+		return nil
+	}
+}
+
+// WithFloatDecimals rounds float values to n decimal places, cutting the
+// size of messages and the noise of full float64 precision downstream
+//
+// values sent as integers, see WithFieldTypes, are rounded to integers
+// either way
+func WithFloatDecimals(n int) Option {
+	return func(hc *HekaClient) error {
+		if n < 0 {
+			return fmt.Errorf("float_decimals: must not be negative, got %d", n)
+		}
+		hc.build.precision = floatPrecision{'f', n}
+		return nil
+	}
+}
+
+// WithSignificantDigits rounds float values to n significant digits, like
+// WithFloatDecimals but keeping the precision of small values such as
+// rates of a fraction per second, the last of the two options given wins
+func WithSignificantDigits(n int) Option {
+	return func(hc *HekaClient) error {
+		if n < 1 {
+			return fmt.Errorf("float_digits: must be at least 1, got %d", n)
+		}
+		hc.build.precision = floatPrecision{'g', n}
+		return nil
+	}
+}
+
+// WithOversizePolicy sets what happens to messages over the size limit, the
+// default is OversizeSplit
+func WithOversizePolicy(p OversizePolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < OversizeSplit || p > OversizeError {
+			return fmt.Errorf("on_oversize: unknown policy %d", p)
+		}
+		hc.onOversize = p
+		return nil
+	}
+}
+
+// WithDuplicatePolicy sets what happens to fields whose names collide, the
+// default is DuplicateSuffix
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < DuplicateSuffix || p > DuplicateAllow {
+			return fmt.Errorf("on_duplicate: unknown policy %d", p)
+		}
+		hc.onDuplicate = p
+		return nil
+	}
+}
+
+// WithDuplicateHook calls f with the name of each duplicate field found,
+// before the DuplicatePolicy is applied
+func WithDuplicateHook(f func(name string)) Option {
+	return func(hc *HekaClient) error {
+		hc.duplicateHook = f
+		return nil
+	}
+}
+
+// WithLargeIntPolicy sets how integer values beyond ±2^53 are sent, the
+// default, LargeIntSend, sends them as integers
+//
+// LargeIntString is meant for Heka, the other outputs leave string fields out
+func WithLargeIntPolicy(p LargeIntPolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < LargeIntSend || p > LargeIntString {
+			return fmt.Errorf("large_ints: unknown policy %d", p)
+		}
+		hc.build.large = p
+		return nil
+	}
+}
+
+// WithNameScheme sets how the parts of metric field names are joined, the
+// default, NamesDotted, keeps the dots of metric names and joins the
+// statistics with dots too
+//
+// static fields keep their names, patterns matched against field names,
+// like those of WithHybridPayload, see the renamed names; for the metric
+// tree as a JSON payload see WithTreePayload
+func WithNameScheme(n NameScheme) Option {
+	return func(hc *HekaClient) error {
+		if n < NamesDotted || n > NamesUnderscore {
+			return fmt.Errorf("name_scheme: unknown scheme %d", n)
+		}
+		hc.build.scheme = n
+		return nil
+	}
+}
+
+// WithInvalidNamePolicy sets what to do with metric names holding invalid
+// UTF-8 or control characters, the default, InvalidNameSend, sends them as
+// they are; they're counted in Stats().InvalidNames either way
+func WithInvalidNamePolicy(p InvalidNamePolicy) Option {
+	return func(hc *HekaClient) error {
+		if p < InvalidNameSend || p > InvalidNameSkip {
+			return fmt.Errorf("invalid_names: unknown policy %d", p)
+		}
+		hc.build.invalidNames = p
+		return nil
+	}
+}
+
+// MinNameLength is the shortest limit WithMaxNameLength takes, room for a
+// few characters of the name, a separator and its hash
+const MinNameLength = 16
+
+// WithMaxNameLength shortens metric field names over n bytes, for
+// dynamically named metrics outgrowing downstream field name limits: they
+// are cut and end with a separator and a hash of the whole name, so they
+// stay unique and are shortened the same way every flush
+//
+// 0, the default, leaves names alone, static fields keep their names
+func WithMaxNameLength(n int) Option {
+	return func(hc *HekaClient) error {
+		if n != 0 && n < MinNameLength {
+			return fmt.Errorf("max_name_length: must be 0 or at least %d, got %d", MinNameLength, n)
+		}
+		hc.build.maxName = n
+		return nil
+	}
+}
+
+// WithFieldTypes sets the protobuf value type used for each kind of metric,
+// by default counters, gauges, counts, minimums and maximums are integers
+// and everything else doubles
+func WithFieldTypes(p FieldTypePolicy) Option {
+	return func(hc *HekaClient) error {
+		for name, t := range p.kinds() {
+			if *t < FieldNative || *t > FieldDouble {
+				return fmt.Errorf("field_types: %s: unknown field type %d", name, *t)
+			}
+		}
+		hc.build.types = p
+		return nil
+	}
+}
+
+// WithSender sends through s instead of dialing the address in the connect
+// string, which still selects how messages are encoded
+//
+// it's meant for tests and custom transports, the client never closes s and
+// on a send error retries the same s rather than reconnecting
+func WithSender(s Sender) Option {
+	return func(hc *HekaClient) error {
+		if s == nil {
+			return fmt.Errorf("sender: nil Sender")
+		}
+		hc.custom = s
+		return nil
+	}
+}
+
+// Deterministic holds the header values fixed by WithDeterministic
+type Deterministic struct {
+	// Uuid is the Uuid of every message, 16 zero bytes if nil
+	Uuid      []byte
+	Timestamp time.Time
+	Hostname  string
+	Pid       int32
+}
+
+// WithDeterministic fixes the Uuid, Timestamp, Hostname and Pid of every
+// message to the values in d and sorts fields by metric name, so the same
+// registry contents always encode to the same bytes, e.g. for golden file
+// tests of the wire format
+//
+// meter and timer rates still depend on when they're read, registries
+// used for golden files should hold fixed values
+func WithDeterministic(d Deterministic) Option {
+	return func(hc *HekaClient) error {
+		u := d.Uuid
+		if u == nil {
+			u = make([]byte, 16)
+		}
+		if len(u) != 16 {
+			return fmt.Errorf("deterministic: uuid must be 16 bytes, got %d", len(u))
+		}
+		u = append([]byte(nil), u...)
+		hc.uuid = func() []byte { return u }
+		ts := d.Timestamp.UnixNano()
+		if d.Timestamp.IsZero() {
+			ts = 0
+		}
+		hc.fixedTime = &ts
+		hc.hostname = d.Hostname
+		hc.pid = d.Pid
+		hc.build.sorted = true
+		return nil
+	}
+}
+
+// WithClock replaces the real time used for flush intervals, eager
+// reconnects, StopWithTimeout's wait, timestamps and log suppression with c
+func WithClock(c Clock) Option {
+	return func(hc *HekaClient) error {
+		if c == nil {
+			return fmt.Errorf("clock: nil Clock")
+		}
+		hc.clock = c
+		hc.log.now = c.Now
+		return nil
+	}
+}
+
+// WithFileRotation sets when 'file' outputs are rotated and how many old
+// files are kept, the defaults are DefaultFileMaxSize and DefaultFileKeep
+//
+// maxSize 0 never rotates, keep 0 discards the file instead of keeping it
+func WithFileRotation(maxSize int64, keep int) Option {
+	return func(hc *HekaClient) error {
+		if maxSize < 0 {
+			return fmt.Errorf("file_max_size: must not be negative, got %d", maxSize)
+		}
+		if keep < 0 {
+			return fmt.Errorf("file_keep: must not be negative, got %d", keep)
+		}
+		hc.fileMaxSize = maxSize
+		hc.fileKeep = keep
+		return nil
+	}
+}
+
+// DefaultAMQPExchange is the exchange 'amqp' outputs publish to
+const DefaultAMQPExchange = "hekametrics"
+
+// WithAMQP sets the exchange and routing key 'amqp' and 'amqps' outputs
+// publish to, they should match the AMQPInput's 'exchange' and
+// 'routing_key'
+//
+// those outputs are only built with the 'amqp' build tag
+func WithAMQP(exchange, routingKey string) Option {
+	return func(hc *HekaClient) error {
+		if exchange == "" {
+			return fmt.Errorf("amqp_exchange: must not be empty")
+		}
+		hc.amqpExchange = exchange
+		hc.amqpRoutingKey = routingKey
+		return nil
+	}
+}
+
+// DefaultNATSSubject is the subject 'nats' outputs publish to
+const DefaultNATSSubject = "hekametrics"
+
+// WithNATS sets the subject 'nats' outputs publish to, that output is only
+// built with the 'nats' build tag
+func WithNATS(subject string) Option {
+	return func(hc *HekaClient) error {
+		if subject == "" {
+			return fmt.Errorf("nats_subject: must not be empty")
+		}
+		hc.natsSubject = subject
+		return nil
+	}
+}
+
+// DefaultFluentTag is the tag of the events 'forward' outputs send
+const DefaultFluentTag = "hekametrics"
+
+// WithFluentTag sets the tag of the events 'forward' outputs send, which
+// Fluentd matches them on
+func WithFluentTag(tag string) Option {
+	return func(hc *HekaClient) error {
+		if tag == "" {
+			return fmt.Errorf("fluent_tag: must not be empty")
+		}
+		hc.fluentTag = tag
+		return nil
+	}
+}
+
+// WithRedis sets the key and mode, RedisList or RedisStream, of 'redis'
+// outputs, the defaults are DefaultRedisKey and RedisList
+func WithRedis(key, mode string) Option {
+	return func(hc *HekaClient) error {
+		if key == "" {
+			return fmt.Errorf("redis_key: must not be empty")
+		}
+		if mode != RedisList && mode != RedisStream {
+			return fmt.Errorf("redis_mode: unknown mode '%s', try '%s' or '%s'", mode, RedisList, RedisStream)
+		}
+		hc.redisKey = key
+		hc.redisMode = mode
+		return nil
+	}
+}
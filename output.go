@@ -0,0 +1,223 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// scheme describes an output selected by the scheme of a connect string
+type scheme struct {
+	// dial connects to the endpoint in u
+	dial func(hc *HekaClient, u *url.URL) (Sender, error)
+	// encode renders a message into the bytes sent, reusing buf
+	encode func(hc *HekaClient, msg *message.Message, buf *[]byte) error
+	// batch is set when several encoded messages can be concatenated into
+	// a single write, for stream transports and senders that split batches
+	batch bool
+	// maxSize is the largest single write the transport carries intact,
+	// bigger messages are split, 0 means no limit
+	maxSize int
+	// heka is set for schemes sending Heka protobuf messages, which are
+	// also held to the client's MaxMessageSize
+	heka bool
+	// payload is set for schemes sending the message's Payload, the others
+	// only send its fields
+	payload bool
+	// addr is what the connect string addresses
+	addr addrKind
+}
+
+// addrKind is the kind of address a scheme's connect strings hold
+type addrKind int
+
+const (
+	// addrHost is a host and port, 'tcp://127.0.0.1:5565'
+	addrHost addrKind = iota
+	// addrPath is an absolute path, 'file:///var/lib/metrics/stats.pb'
+	addrPath
+	// addrNone is nothing at all, 'stdout://'
+	addrNone
+)
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 65507
+
+var schemes = map[string]scheme{
+	"tcp":         {dial: dialNet("tcp"), encode: encodeHeka, batch: true, heka: true, payload: true},
+	"udp":         {dial: dialNet("udp"), encode: encodeHeka, maxSize: maxDatagram, heka: true, payload: true},
+	"graphite":    {dial: dialNet("tcp"), encode: encodeGraphite, batch: true},
+	"influx+udp":  {dial: dialNet("udp"), encode: encodeInflux, maxSize: maxDatagram},
+	"influx+http": {dial: dialInfluxHTTP, encode: encodeInflux, batch: true},
+	"statsd":      {dial: dialStatsd, encode: encodeStatsd, batch: true},
+	"syslog":      {dial: dialNet("udp"), encode: encodeSyslog, maxSize: maxDatagram, payload: true},
+	"syslog+tcp":  {dial: dialSyslogTCP, encode: encodeSyslog, payload: true},
+	"collectd":    {dial: dialCollectd, encode: encodeCollectd, batch: true},
+	"redis":       {dial: dialRedis, encode: encodeHeka, heka: true, payload: true},
+	"forward":     {dial: dialNet("tcp"), encode: encodeForward, batch: true, payload: true},
+	"lumberjack":  {dial: dialLumberjack, encode: encodeLumberjack, payload: true},
+	"file":        {dial: openFile, encode: encodeHeka, batch: true, heka: true, addr: addrPath, payload: true},
+	"stdout":      {dial: dialStdout, encode: encodeHeka, batch: true, heka: true, addr: addrNone, payload: true},
+	"stderr":      {dial: dialStderr, encode: encodeHeka, batch: true, heka: true, addr: addrNone, payload: true},
+	"text+stdout": {dial: dialStdout, encode: encodeText, batch: true, addr: addrNone},
+	"text+stderr": {dial: dialStderr, encode: encodeText, batch: true, addr: addrNone},
+	"json+stdout": {dial: dialStdout, encode: encodeJSONLine, batch: true, addr: addrNone, payload: true},
+	"json+stderr": {dial: dialStderr, encode: encodeJSONLine, batch: true, addr: addrNone, payload: true},
+}
+
+// dialNet returns a dial func for a net.Dial network
+func dialNet(network string) func(*HekaClient, *url.URL) (Sender, error) {
+	return func(hc *HekaClient, u *url.URL) (Sender, error) {
+		s, err := dialSender(hc, network, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// schemeNames lists the supported schemes for error messages
+func schemeNames() string {
+	names := make([]string, 0, len(schemes))
+	for name, s := range schemes {
+		switch s.addr {
+		case addrPath:
+			names = append(names, "'"+name+":///<path>'")
+		case addrNone:
+			names = append(names, "'"+name+"://'")
+		default:
+			names = append(names, "'"+name+"://<host>:<port>'")
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// checkMultiValues fails for schemes that send one value per field, which
+// would keep only the first statistic of each WithMultiValueFields field
+func checkMultiValues(u *url.URL) error {
+	if !schemes[u.Scheme].heka {
+		return fmt.Errorf("multi_value_fields: '%s://' outputs send one value per field, try a Heka protobuf scheme", u.Scheme)
+	}
+	return nil
+}
+
+// checkPayload fails for schemes that only send fields, which would drop
+// the metrics the setting named key moves to the payload
+func checkPayload(u *url.URL, key string) error {
+	if !schemes[u.Scheme].payload {
+		return fmt.Errorf("%s: '%s://' outputs don't send the payload holding the metrics, try a Heka protobuf or JSON scheme", key, u.Scheme)
+	}
+	return nil
+}
+
+// checkOutputs fails when the client or one of its sinks, which are sent
+// the messages it builds, can't encode them as configured
+func (hc *HekaClient) checkOutputs() error {
+	if hc.connect_s == nil {
+		return nil
+	}
+	payload := ""
+	switch {
+	case hc.statmetric:
+		payload = "statmetric"
+	case hc.tree:
+		payload = "tree_payload"
+	case hc.hybrid != nil:
+		payload = "hybrid_payload"
+	}
+	urls := []*url.URL{hc.connect_s}
+	for _, s := range hc.sinks {
+		urls = append(urls, s.connect_s)
+	}
+	for i, u := range urls {
+		var err error
+		if hc.build.multi {
+			err = checkMultiValues(u)
+		}
+		if err == nil && payload != "" {
+			err = checkPayload(u, payload)
+		}
+		if err != nil && i > 0 {
+			return fmt.Errorf("sink: %s", err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redacted returns u with any password replaced by 'xxxxx', for logs and
+// errors, connect strings for Redis, AMQP, NATS and InfluxDB carry them
+func redacted(u *url.URL) string {
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+	r := *u
+	r.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return r.String()
+}
+
+// isStatic reports whether f is one of the fields added with WithField or
+// WithPartition, or the SequenceField or CaptureField, rather than a metric
+func (hc *HekaClient) isStatic(f *message.Field) bool {
+	if name := f.GetName(); name == SequenceField || name == CaptureField {
+		return true
+	}
+	return hc.isTag(f)
+}
+
+// isTag reports whether f is one of the fields added with WithField or
+// WithPartition, which describe the source of the metrics
+func (hc *HekaClient) isTag(f *message.Field) bool {
+	for _, s := range hc.fields {
+		if s == f {
+			return true
+		}
+	}
+	for _, p := range hc.partitions {
+		for _, s := range p.fields {
+			if s == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodeHeka frames msg as a native Heka protobuf stream
+func encodeHeka(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	return hc.encoder.EncodeMessageStream(msg, buf)
+}
@@ -0,0 +1,157 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+)
+
+// DefaultMaxMessageSize matches the largest message Heka accepts by default
+const DefaultMaxMessageSize = message.MAX_MESSAGE_SIZE
+
+// TruncatedField is added to messages cut down by OversizeTruncate, holding
+// the number of metric fields dropped
+const TruncatedField = "hekametrics.truncated"
+
+// OversizePolicy says what happens to a message whose encoding exceeds the
+// MaxMessageSize, or the largest datagram the scheme can send
+type OversizePolicy int
+
+const (
+	// OversizeSplit divides the metric fields between two messages, as
+	// often as needed
+	OversizeSplit OversizePolicy = iota
+	// OversizeTruncate drops metric fields from the end of the message
+	// until it fits and records how many in a TruncatedField
+	OversizeTruncate
+	// OversizeError drops the message, passing a *MessageSizeError to the
+	// handler set with WithEncodeErrorHandler
+	OversizeError
+)
+
+var oversizePolicies = map[string]OversizePolicy{"split": OversizeSplit, "truncate": OversizeTruncate, "error": OversizeError}
+
+// ParseOversizePolicy parses 'split', 'truncate' or 'error'
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	p, ok := oversizePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown oversize policy '%s', try 'split', 'truncate' or 'error'", s)
+	}
+	return p, nil
+}
+
+// MessageSizeError reports a message that couldn't be brought under the
+// size limit and wasn't sent
+type MessageSizeError struct {
+	Size, Max int
+}
+
+func (e *MessageSizeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds %d", e.Size, e.Max)
+}
+
+// sizeLimit is the largest encoded message the client sends, 0 for none
+func (hc *HekaClient) sizeLimit() int {
+	limit := hc.scheme.maxSize
+	if hc.scheme.heka && hc.maxMessageSize > 0 && (limit == 0 || hc.maxMessageSize < limit) {
+		limit = hc.maxMessageSize
+	}
+	return limit
+}
+
+// oversize handles msg, whose encoding in hc.frame exceeds limit, according
+// to the client's OversizePolicy
+func (hc *HekaClient) oversize(msg *message.Message, limit int) error {
+	size := len(hc.frame)
+	switch hc.onOversize {
+	case OversizeSplit:
+		if a, b, ok := hc.split(msg); ok {
+			err := hc.emit(a)
+			if berr := hc.emit(b); err == nil {
+				err = berr
+			}
+			return err
+		}
+	case OversizeTruncate:
+		ok, err := hc.truncate(msg, limit)
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	err := &MessageSizeError{Size: size, Max: limit}
+	hc.countEncodeError()
+	hc.log.Printf("Inject: [error] dropping message: %s\n", err)
+	if hc.encodeHandler != nil {
+		hc.encodeHandler(msg, err)
+	}
+	return err
+}
+
+// truncate re-encodes msg with fewer metric fields, shrinking in
+// proportion to the overshoot until it fits in limit, and outputs it
+//
+// it reports false when even the static fields alone are too large
+func (hc *HekaClient) truncate(msg *message.Message, limit int) (bool, error) {
+	var metricFields, static []*message.Field
+	for _, f := range msg.Fields {
+		if hc.isStatic(f) {
+			static = append(static, f)
+		} else {
+			metricFields = append(metricFields, f)
+		}
+	}
+
+	m := *msg
+	keep := len(metricFields)
+	for size := len(hc.frame); size > limit; size = len(hc.frame) {
+		if keep == 0 {
+			return false, nil
+		}
+		n := keep * limit / size
+		if n >= keep {
+			n = keep - 1
+		}
+		keep = n
+
+		marker, err := message.NewField(TruncatedField, int64(len(metricFields)-keep), "count")
+		if err != nil {
+			return false, err
+		}
+		m.Fields = append(metricFields[:keep:keep], static...)
+		m.Fields = append(m.Fields, marker)
+		if err = hc.encode(&m); err != nil {
+			return false, err
+		}
+	}
+	hc.log.Printf("Inject: [error] truncated message to %d of %d fields\n", keep, len(metricFields))
+	return true, hc.output()
+}
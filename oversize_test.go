@@ -0,0 +1,198 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"strings"
+	"testing"
+)
+
+// oversizeLimit is small enough that a hundred counters need several
+// messages
+const oversizeLimit = 1000
+
+// oversizeClient returns a client for policy sending to a recordingSender
+// with a static 'region' field and a registry with n counters
+func oversizeClient(t *testing.T, policy OversizePolicy, n int, opts ...Option) (*HekaClient, *recordingSender, metrics.Registry) {
+	s := &recordingSender{}
+	opts = append([]Option{WithSender(s), WithField("region", "us-east"),
+		WithMaxMessageSize(oversizeLimit), WithOversizePolicy(policy)}, opts...)
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	for i := 0; i < n; i++ {
+		metrics.GetOrRegisterCounter(fmt.Sprintf("api.requests.%03d", i), r).Inc(int64(i))
+	}
+	return hc, s, r
+}
+
+// recordSizes returns the size of every framed record sent
+func recordSizes(t *testing.T, s *recordingSender) []int {
+	var sizes []int
+	for _, b := range s.sent {
+		for len(b) > 0 {
+			_, _, rest := readRecord(t, b)
+			sizes = append(sizes, len(b)-len(rest))
+			b = rest
+		}
+	}
+	return sizes
+}
+
+// regionOf returns the 'region' field of msg, empty without one
+func regionOf(msg *message.Message) string {
+	for _, f := range msg.GetFields() {
+		if f.GetName() == "region" {
+			v, _ := f.GetValue().(string)
+			return v
+		}
+	}
+	return ""
+}
+
+func TestOversizeSplit(t *testing.T) {
+	hc, s, r := oversizeClient(t, OversizeSplit, 100)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	sizes := recordSizes(t, s)
+	if len(sizes) < 2 {
+		t.Fatalf("%d messages, expected the metrics split between several", len(sizes))
+	}
+	for i, size := range sizes {
+		if size > oversizeLimit {
+			t.Errorf("message %d: %d bytes exceeds %d", i, size, oversizeLimit)
+		}
+	}
+
+	uuids := make(map[string]bool)
+	metricFields := make(map[string]bool)
+	for i, msg := range s.messages(t) {
+		if regionOf(msg) != "us-east" {
+			t.Errorf("message %d: no static 'region' field", i)
+		}
+		if uuids[string(msg.GetUuid())] {
+			t.Errorf("message %d: Uuid %x repeated", i, msg.GetUuid())
+		}
+		uuids[string(msg.GetUuid())] = true
+		for _, name := range fieldNames(msg) {
+			if name == "region" {
+				continue
+			}
+			if metricFields[name] {
+				t.Errorf("message %d: field '%s' sent twice", i, name)
+			}
+			metricFields[name] = true
+		}
+	}
+	if len(metricFields) != 100 {
+		t.Errorf("%d metric fields sent, expected 100", len(metricFields))
+	}
+}
+
+func TestOversizeTruncate(t *testing.T) {
+	hc, s, r := oversizeClient(t, OversizeTruncate, 100)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	sizes := recordSizes(t, s)
+	if len(sizes) != 1 || sizes[0] > oversizeLimit {
+		t.Fatalf("got messages of %v bytes, expected one up to %d", sizes, oversizeLimit)
+	}
+	msg := s.messages(t)[0]
+	if regionOf(msg) != "us-east" {
+		t.Errorf("no static 'region' field")
+	}
+	var kept, dropped int64
+	for _, f := range msg.GetFields() {
+		switch {
+		case f.GetName() == TruncatedField:
+			dropped = f.GetValueInteger()[0]
+		case strings.HasPrefix(f.GetName(), "api.requests."):
+			kept++
+		}
+	}
+	if dropped == 0 || kept+dropped != 100 {
+		t.Errorf("kept %d and dropped %d fields, expected some dropped out of 100", kept, dropped)
+	}
+}
+
+func TestOversizeError(t *testing.T) {
+	var handled []error
+	hc, s, r := oversizeClient(t, OversizeError, 100,
+		WithEncodeErrorHandler(func(msg *message.Message, err error) { handled = append(handled, err) }))
+	err := hc.Flush(r)
+	if serr, ok := err.(*MessageSizeError); !ok || serr.Max != oversizeLimit || serr.Size <= oversizeLimit {
+		t.Fatalf("got %v, expected a MessageSizeError", err)
+	}
+	if len(s.sent) != 0 {
+		t.Errorf("%d writes, expected the message dropped", len(s.sent))
+	}
+	if len(handled) != 1 || handled[0] != err {
+		t.Errorf("handler got %v", handled)
+	}
+	if hc.Stats().EncodeErrors != 1 {
+		t.Errorf("%d encode errors counted, want 1", hc.Stats().EncodeErrors)
+	}
+}
+
+func TestOversizeField(t *testing.T) {
+	// a single field too large for any message
+	long := strings.Repeat("x", 2*oversizeLimit)
+
+	hc, s, r := oversizeClient(t, OversizeSplit, 1)
+	metrics.GetOrRegisterCounter(long, r).Inc(1)
+	if _, ok := hc.Flush(r).(*MessageSizeError); !ok {
+		t.Errorf("split: expected a MessageSizeError for the long field")
+	}
+	msgs := s.messages(t)
+	if len(msgs) != 1 || len(msgs[0].GetFields()) != 2 {
+		t.Errorf("split: got %d messages, expected the part without the long field", len(msgs))
+	}
+
+	hc, s, r = oversizeClient(t, OversizeTruncate, 0)
+	metrics.GetOrRegisterCounter(long, r).Inc(1)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	msgs = s.messages(t)
+	if len(msgs) != 1 {
+		t.Fatalf("truncate: got %d messages, want 1", len(msgs))
+	}
+	names := fieldNames(msgs[0])
+	if len(names) != 2 || names[0] != "region" || names[1] != TruncatedField {
+		t.Errorf("truncate: got fields %v, expected only the static and truncated fields", names)
+	}
+}
@@ -0,0 +1,114 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"sort"
+)
+
+// Partition is a subset of a registry's metrics sent as a message of its
+// own, with its own 'Type' and static fields, e.g. for a process reporting
+// on behalf of several tenants, written in a TOML config as
+//
+//	[[partitions]]
+//	names = ["tenant_a.*"]
+//	type = "tenant_a.metrics"
+//	fields = { tenant = "a" }
+type Partition struct {
+	// Names are path.Match style patterns, like WithInclude's
+	Names []string `json:"names" toml:"names"`
+	// Type is the message 'Type', the client's when empty
+	Type string `json:"type" toml:"type"`
+	// Fields are static fields added after the client's
+	Fields map[string]interface{} `json:"fields" toml:"fields"`
+}
+
+// partition is a Partition ready for flushing
+type partition struct {
+	names   []string
+	msgtype string
+	fields  []*message.Field
+}
+
+// newPartition checks p and builds its fields, in name order
+func newPartition(p Partition) (*partition, error) {
+	if len(p.Names) == 0 {
+		return nil, fmt.Errorf("names: must not be empty")
+	}
+	if err := checkPatterns(p.Names); err != nil {
+		return nil, fmt.Errorf("names: %s", err)
+	}
+	names := make([]string, 0, len(p.Fields))
+	for name := range p.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	part := &partition{names: append([]string(nil), p.Names...), msgtype: p.Type}
+	for _, name := range names {
+		f, err := message.NewField(name, p.Fields[name], "")
+		if err != nil {
+			return nil, fmt.Errorf("fields: %s: %s", name, err)
+		}
+		part.fields = append(part.fields, f)
+	}
+	return part, nil
+}
+
+// split returns the samples in the partition and the others
+func (p *partition) split(samples []sample) (in, out []sample) {
+	for _, s := range samples {
+		if matchAny(p.names, s.name) {
+			in = append(in, s)
+		} else {
+			out = append(out, s)
+		}
+	}
+	return in, out
+}
+
+// WithPartition sends the metrics matching p.Names as a message of their
+// own, with p's 'Type' and static fields, instead of in the client's
+// message, with every output
+//
+// partitions are tried in the order they're added and the first match
+// wins, a partition without metrics in a flush sends nothing, and
+// MakeMessage and PrometheusHandler don't partition
+func WithPartition(p Partition) Option {
+	return func(hc *HekaClient) error {
+		part, err := newPartition(p)
+		if err != nil {
+			return fmt.Errorf("partitions: %s", err)
+		}
+		hc.partitions = append(hc.partitions, part)
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+)
+
+// ProbeType is the 'Type' of the message Ping sends
+const ProbeType = "hekametrics.probe"
+
+// Ping connects to Heka unless the client already is, and with probe also
+// sends a message of Type ProbeType with no metrics, returning the error,
+// so deployment smoke tests can check Heka is reachable before serving
+// traffic
+//
+// over udp dialing can't fail once the address resolves, only the probe,
+// and only sometimes, tells whether anything listens; sinks aren't pinged
+func (hc *HekaClient) Ping(probe bool) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.sender == nil {
+		if err := hc.reconnect(); err != nil {
+			return err
+		}
+	}
+	if !probe {
+		return nil
+	}
+	ts := hc.clock.Now().UnixNano() + hc.clockOffset()
+	return hc.send([]*message.Message{hc.envelope(stamp{ts: ts}, ProbeType)})
+}
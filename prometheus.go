@@ -0,0 +1,143 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"github.com/rcrowley/go-metrics"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PrometheusHandler returns an http.Handler exposing the metrics in r in the
+// Prometheus text format, so they can be scraped while also being pushed to
+// Heka
+//
+// each field the client would send becomes an untyped sample, with names
+// mapped to Prometheus' character set ('api.latency.timer.mean' is
+// 'api_latency_timer_mean') and the static fields as labels
+//
+// the samples are the metric fields as built before the payload options and
+// WithMultiValueFields reshape them, so every statistic is scraped whatever
+// the client sends to Heka
+func (hc *HekaClient) PrometheusHandler(r metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var b bytes.Buffer
+		writePrometheus(&b, hc, hc.prometheusMessage(r))
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(b.Bytes())
+	})
+}
+
+// prometheusMessage builds the metric fields of a snapshot of r with one
+// value each
+func (hc *HekaClient) prometheusMessage(r metrics.Registry) *message.Message {
+	return build_message(hc.top(hc.samples(r)), &hc.scrape)
+}
+
+func writePrometheus(b *bytes.Buffer, hc *HekaClient, msg *message.Message) {
+	var labels bytes.Buffer
+	for _, f := range hc.fields {
+		if labels.Len() > 0 {
+			labels.WriteByte(',')
+		}
+		fmt.Fprintf(&labels, "%s=\"%s\"",
+			strings.Replace(prometheusName(f.GetName()), ":", "_", -1),
+			labelEscaper.Replace(fmt.Sprint(f.GetValue())))
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		var value string
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if len(f.GetValueInteger()) == 0 {
+				continue
+			}
+			value = strconv.FormatInt(f.GetValueInteger()[0], 10)
+		case message.Field_DOUBLE:
+			if len(f.GetValueDouble()) == 0 {
+				continue
+			}
+			v := f.GetValueDouble()[0]
+			switch {
+			case math.IsNaN(v):
+				value = "NaN"
+			case math.IsInf(v, 1):
+				value = "+Inf"
+			case math.IsInf(v, -1):
+				value = "-Inf"
+			default:
+				value = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		default:
+			continue
+		}
+		name := prometheusName(f.GetName())
+		if seen[name] {
+			// the exposition format allows a name only once
+			continue
+		}
+		seen[name] = true
+		fmt.Fprintf(b, "# TYPE %s untyped\n", name)
+		b.WriteString(name)
+		if labels.Len() > 0 {
+			b.WriteByte('{')
+			b.Write(labels.Bytes())
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// prometheusName maps name onto [a-zA-Z_:][a-zA-Z0-9_:]*
+func prometheusName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,83 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the sorted lines PrometheusHandler serves for r, the
+// registry's order varies
+func scrape(t *testing.T, r metrics.Registry, opts ...Option) string {
+	opts = append([]Option{WithSender(&recordingSender{}), WithField("region", "us-east")}, opts...)
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	hc.PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	lines := strings.SplitAfter(w.Body.String(), "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+func TestPrometheusHandlerOptions(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	api := metrics.GetOrRegisterTimer("api", r)
+	api.Update(2 * time.Millisecond)
+	api.Update(4 * time.Millisecond)
+
+	want := scrape(t, r)
+	for _, line := range []string{
+		`requests{region="us-east"} 3`,
+		`api_timer_count{region="us-east"} 2`,
+		`api_timer_mean{region="us-east"} 3e+06`,
+	} {
+		if !strings.Contains(want, line+"\n") {
+			t.Fatalf("missing '%s' in:\n%s", line, want)
+		}
+	}
+	for name, opt := range map[string]Option{
+		"multi_value_fields": WithMultiValueFields(),
+		"hybrid_payload":     WithHybridPayload("requests"),
+		"tree_payload":       WithTreePayload(),
+		"statmetric":         WithStatmetric(),
+	} {
+		if got := scrape(t, r, opt); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
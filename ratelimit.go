@@ -0,0 +1,84 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"context"
+	"time"
+)
+
+// byteLimiter is a token bucket of bytes refilled at rate per second, holding
+// at most one second's worth
+type byteLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(bytesPerSecond int) *byteLimiter {
+	return &byteLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond)}
+}
+
+// wait takes n bytes from the bucket, sleeping until the debt is repaid when
+// there aren't enough
+func (l *byteLimiter) wait(n int) {
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// limitedSender throttles writes to a Sender with a byteLimiter, which is
+// shared across reconnects so a reconnect burst is throttled too
+type limitedSender struct {
+	Sender
+	limiter *byteLimiter
+}
+
+func (s limitedSender) SendMessage(b []byte) error {
+	s.limiter.wait(len(b))
+	return s.Sender.SendMessage(b)
+}
+
+func (s limitedSender) SendMessageContext(ctx context.Context, b []byte) error {
+	s.limiter.wait(len(b))
+	if cs, ok := s.Sender.(contextSender); ok {
+		return cs.SendMessageContext(ctx, b)
+	}
+	return s.Sender.SendMessage(b)
+}
@@ -0,0 +1,171 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRedisKey is the list or stream 'redis' outputs add to
+	DefaultRedisKey = "hekametrics"
+	// RedisList LPUSHes each message onto a list
+	RedisList = "list"
+	// RedisStream XADDs each message to a stream, as its 'data' entry
+	RedisStream = "stream"
+)
+
+// redisSender speaks just enough of the Redis protocol to add each framed
+// message to a list or stream, a Heka side consumer pops them and feeds
+// them to a ProtobufDecoder
+type redisSender struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	cmd     [][]byte
+	buf     []byte
+}
+
+// dialRedis connects to the server in u, 'redis://:password@host:port/db',
+// authenticating and selecting the database when they're given
+func dialRedis(hc *HekaClient, u *url.URL) (Sender, error) {
+	conn, err := hc.dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisSender{conn: conn, r: bufio.NewReader(conn), timeout: hc.timeout}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			if err = s.do([]byte("AUTH"), []byte(password)); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("redis: auth: %s", err)
+			}
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err = strconv.Atoi(db); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: database '%s' isn't a number", db)
+		}
+		if err = s.do([]byte("SELECT"), []byte(db)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: select: %s", err)
+		}
+	}
+
+	key := []byte(hc.redisKey)
+	if hc.redisMode == RedisStream {
+		s.cmd = [][]byte{[]byte("XADD"), key, []byte("*"), []byte("data"), nil}
+	} else {
+		s.cmd = [][]byte{[]byte("LPUSH"), key, nil}
+	}
+	return s, nil
+}
+
+func (s *redisSender) SendMessage(b []byte) error {
+	s.cmd[len(s.cmd)-1] = b
+	return s.do(s.cmd...)
+}
+
+// do sends a command and reads its reply, returning error replies as errors
+func (s *redisSender) do(args ...[]byte) error {
+	if s.timeout > 0 {
+		s.conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	s.buf = append(s.buf[:0], '*')
+	s.buf = strconv.AppendInt(s.buf, int64(len(args)), 10)
+	s.buf = append(s.buf, "\r\n"...)
+	for _, arg := range args {
+		s.buf = append(s.buf, '$')
+		s.buf = strconv.AppendInt(s.buf, int64(len(arg)), 10)
+		s.buf = append(s.buf, "\r\n"...)
+		s.buf = append(s.buf, arg...)
+		s.buf = append(s.buf, "\r\n"...)
+	}
+	n, err := s.conn.Write(s.buf)
+	if err != nil || n != len(s.buf) {
+		return &SendError{Wrote: n, Len: len(s.buf), Err: err}
+	}
+	if err = s.reply(); err != nil {
+		if _, ok := err.(redisError); ok {
+			return err
+		}
+		// the next reply read would be this one's leftovers
+		return &SendError{Wrote: n, Len: n, Err: err, Reply: true}
+	}
+	return nil
+}
+
+// redisError is an error reply, after which the connection is still in step
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// reply reads a status, integer or bulk string reply, returning error
+// replies as a redisError
+func (s *redisSender) reply() error {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("redis: bad reply '%s'", line)
+		}
+		if n < 0 {
+			return nil
+		}
+		_, err = s.r.Discard(n + 2)
+		return err
+	}
+	return fmt.Errorf("redis: unexpected reply '%s'", line)
+}
+
+func (s *redisSender) Close() {
+	s.conn.Close()
+}
@@ -0,0 +1,161 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readRESP reads one command as an array of bulk strings
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if line[0] != '*' || !strings.HasSuffix(line, "\r\n") {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || line[0] != '$' {
+			return nil, io.ErrUnexpectedEOF
+		}
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if string(arg[size:]) != "\r\n" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+// redisServer answers each command read from conn with the next reply and
+// sends the commands on cmds
+func redisServer(conn net.Conn, replies []string, cmds chan<- []string) {
+	defer conn.Close()
+	defer close(cmds)
+	r := bufio.NewReader(conn)
+	for _, reply := range replies {
+		args, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		cmds <- args
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestRedisSend(t *testing.T) {
+	for mode, want := range map[string]string{
+		RedisList:   "LPUSH metrics \x1e\x00frame",
+		RedisStream: "XADD metrics * data \x1e\x00frame",
+	} {
+		client, server := net.Pipe()
+		cmds := make(chan []string, 4)
+		go redisServer(server, []string{":1\r\n", "$15\r\n1526919030474-0\r\n", "+OK\r\n"}, cmds)
+
+		s := &redisSender{conn: client, r: bufio.NewReader(client)}
+		if mode == RedisStream {
+			s.cmd = [][]byte{[]byte("XADD"), []byte("metrics"), []byte("*"), []byte("data"), nil}
+		} else {
+			s.cmd = [][]byte{[]byte("LPUSH"), []byte("metrics"), nil}
+		}
+		for i := 0; i < 3; i++ {
+			if err := s.SendMessage([]byte("\x1e\x00frame")); err != nil {
+				t.Errorf("%s: send %d: %s", mode, i, err)
+			}
+		}
+		s.Close()
+		n := 0
+		for args := range cmds {
+			if got := strings.Join(args, " "); got != want {
+				t.Errorf("%s: got command %q, want %q", mode, got, want)
+			}
+			n++
+		}
+		if n != 3 {
+			t.Errorf("%s: server read %d commands, want 3", mode, n)
+		}
+	}
+}
+
+func TestRedisErrorReply(t *testing.T) {
+	client, server := net.Pipe()
+	cmds := make(chan []string, 2)
+	go redisServer(server, []string{"-WRONGTYPE not a list\r\n", ":2\r\n"}, cmds)
+
+	s := &redisSender{conn: client, r: bufio.NewReader(client), cmd: [][]byte{[]byte("LPUSH"), []byte("k"), nil}}
+	err := s.SendMessage([]byte("a"))
+	if err == nil || brokenStream(err) {
+		t.Fatalf("expected an error reply keeping the connection, got %v", err)
+	}
+	if err = s.SendMessage([]byte("b")); err != nil {
+		t.Errorf("send after an error reply: %s", err)
+	}
+	s.Close()
+}
+
+func TestRedisBadReply(t *testing.T) {
+	for name, reply := range map[string]string{
+		"garbled":   "?what\r\n",
+		"truncated": "$10\r\nabc",
+		"bad bulk":  "$x\r\n",
+		"no reply":  "",
+	} {
+		client, server := net.Pipe()
+		cmds := make(chan []string, 1)
+		go redisServer(server, []string{reply}, cmds)
+
+		s := &redisSender{conn: client, r: bufio.NewReader(client), cmd: [][]byte{[]byte("LPUSH"), []byte("k"), nil}}
+		err := s.SendMessage([]byte("a"))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if !brokenStream(err) {
+			t.Errorf("%s: connection kept after %s", name, err)
+		}
+		s.Close()
+	}
+}
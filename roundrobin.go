@@ -0,0 +1,92 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"net/url"
+	"strings"
+)
+
+// roundRobin spreads flushes over the comma separated hosts of a connect
+// string, 'tcp://heka1:5565,heka2:5565', keeping a connection to each,
+// guarded by hc.mu
+type roundRobin struct {
+	hosts []string
+	// cur is the host hc.sender is connected to, the others' connections
+	// wait in parked
+	cur    int
+	parked []Sender
+}
+
+// reset takes the hosts of u, closing the parked connections
+func (r *roundRobin) reset(u *url.URL) {
+	r.closeParked()
+	r.hosts, r.cur, r.parked = nil, 0, nil
+	if hosts := strings.Split(u.Host, ","); len(hosts) > 1 {
+		r.hosts = hosts
+		r.parked = make([]Sender, len(hosts))
+	}
+}
+
+// url returns u with the current host
+func (r *roundRobin) url(u *url.URL) *url.URL {
+	if len(r.hosts) == 0 {
+		return u
+	}
+	c := *u
+	c.Host = r.hosts[r.cur]
+	return &c
+}
+
+func (r *roundRobin) closeParked() {
+	for i, s := range r.parked {
+		if s != nil {
+			s.Close()
+			r.parked[i] = nil
+		}
+	}
+}
+
+// rotate moves the client on to the next host, parking the current
+// connection, hc.mu must be held
+func (hc *HekaClient) rotate() {
+	r := &hc.rr
+	if len(r.hosts) == 0 {
+		return
+	}
+	if hc.custom == nil {
+		r.parked[r.cur] = hc.sender
+	}
+	r.cur = (r.cur + 1) % len(r.hosts)
+	if hc.custom == nil {
+		hc.sender = r.parked[r.cur]
+		r.parked[r.cur] = nil
+	}
+}
@@ -0,0 +1,174 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"context"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hostRecorder dials pipes, counting the bytes written to each address and
+// the connections closed
+type hostRecorder struct {
+	mu      sync.Mutex
+	dials   []string
+	written map[string]int
+	closed  int
+}
+
+func (h *hostRecorder) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	h.mu.Lock()
+	h.dials = append(h.dials, addr)
+	h.mu.Unlock()
+	return &recordedConn{c, h, addr}, nil
+}
+
+type recordedConn struct {
+	net.Conn
+	h    *hostRecorder
+	addr string
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	c.h.mu.Lock()
+	c.h.written[c.addr] += len(b)
+	c.h.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordedConn) Close() error {
+	c.h.mu.Lock()
+	c.h.closed++
+	c.h.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// open returns how many connections were dialed and not closed
+func (h *hostRecorder) open() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.dials) - h.closed
+}
+
+func (h *hostRecorder) counts() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := make(map[string]int, len(h.written))
+	for addr, n := range h.written {
+		counts[addr] = n
+	}
+	return counts
+}
+
+func TestRoundRobinOrder(t *testing.T) {
+	h := &hostRecorder{written: make(map[string]int)}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5001,127.0.0.1:5002,127.0.0.1:5003", "stats", WithDialFunc(h.dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+
+	want := []string{"127.0.0.1:5001", "127.0.0.1:5002", "127.0.0.1:5003", "127.0.0.1:5001", "127.0.0.1:5002"}
+	for i, addr := range want {
+		before := h.counts()
+		if err = hc.Flush(r); err != nil {
+			t.Fatal(err)
+		}
+		after := h.counts()
+		for a, n := range after {
+			if grew := n > before[a]; grew != (a == addr) {
+				t.Errorf("flush %d: %d bytes written to %s, want flush at %s", i+1, n-before[a], a, addr)
+			}
+		}
+	}
+	// the connections are kept for the next turn
+	if len(h.dials) != 3 {
+		t.Errorf("dialed %q, want each host once", h.dials)
+	}
+}
+
+// stopClient returns a round robin client over three hosts with a sink,
+// all dialed by h, and a registry with a counter
+func stopClient(t *testing.T, h *hostRecorder, opts ...Option) (*HekaClient, metrics.Registry) {
+	opts = append([]Option{WithDialFunc(h.dial), WithSink("tcp://127.0.0.1:6000")}, opts...)
+	hc, err := NewHekaClient("tcp://127.0.0.1:5001,127.0.0.1:5002,127.0.0.1:5003", "stats", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	return hc, r
+}
+
+func TestStopClosesConnections(t *testing.T) {
+	h := &hostRecorder{written: make(map[string]int)}
+	hc, r := stopClient(t, h)
+	for i := 0; i < 3; i++ {
+		if err := hc.Flush(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a connection to each host, two of them parked, and the sink's
+	if len(h.dials) != 4 || h.open() != 4 {
+		t.Fatalf("dialed %q, %d open, want 4", h.dials, h.open())
+	}
+	hc.Stop()
+	waitFor(t, "the connections to close", func() bool { return h.open() == 0 })
+	hc.Stop()
+}
+
+func TestStopWithTimeoutClosesConnections(t *testing.T) {
+	clock := newTestClock()
+	h := &hostRecorder{written: make(map[string]int)}
+	hc, r := stopClient(t, h, WithClock(clock))
+	go hc.LogHeka(r, time.Second)
+	waitFor(t, "LogHeka to start", func() bool { return clock.running() == 1 })
+	for i := 1; i <= 2; i++ {
+		clock.advance(time.Second)
+		waitFor(t, "a flush", func() bool { return hc.Stats().Flushes == int64(i) })
+	}
+	if n := hc.StopWithTimeout(time.Minute); n != 0 {
+		t.Errorf("%d messages left, want 0", n)
+	}
+	// the final flush went to the third host
+	if len(h.dials) != 4 {
+		t.Errorf("dialed %q, want each host and the sink once", h.dials)
+	}
+	waitFor(t, "the connections to close", func() bool { return h.open() == 0 })
+}
@@ -0,0 +1,154 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+)
+
+// Route selects the metrics sent to a sink added with WithRoute
+//
+// a metric is routed when its name matches one of Names and its kind is one
+// of Kinds, an empty list matches everything
+type Route struct {
+	// Names are path.Match style patterns, like WithInclude's
+	Names []string
+	// Kinds are 'counters', 'gauges', 'gauge_floats', 'histograms',
+	// 'meters' or 'timers'
+	Kinds []string
+}
+
+// check returns a descriptive error for a bad pattern or kind in r
+func (r *Route) check() error {
+	if err := checkPatterns(r.Names); err != nil {
+		return fmt.Errorf("names: %s", err)
+	}
+	var p FieldTypePolicy
+	kinds := p.kinds()
+	for _, k := range r.Kinds {
+		if _, ok := kinds[k]; !ok {
+			return fmt.Errorf("kinds: unknown metric kind '%s', try %s", k, kindNames())
+		}
+	}
+	return nil
+}
+
+// match reports whether s is routed by r
+func (r *Route) match(s sample) bool {
+	if len(r.Names) > 0 && !matchAny(r.Names, s.name) {
+		return false
+	}
+	if len(r.Kinds) == 0 {
+		return true
+	}
+	kind := kindOf(s.metric)
+	for _, k := range r.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the samples routed by r
+func (r *Route) pick(samples []sample) []sample {
+	var picked []sample
+	for _, s := range samples {
+		if r.match(s) {
+			picked = append(picked, s)
+		}
+	}
+	return picked
+}
+
+// kindOf names the kind of metric, as spelled in a FieldTypePolicy
+func kindOf(metric interface{}) string {
+	switch metric.(type) {
+	case metrics.Counter:
+		return "counters"
+	case metrics.Gauge:
+		return "gauges"
+	case metrics.GaugeFloat64:
+		return "gauge_floats"
+	case metrics.Histogram:
+		return "histograms"
+	case metrics.Meter:
+		return "meters"
+	case metrics.Timer:
+		return "timers"
+	}
+	return ""
+}
+
+// WithRoute sends the metrics selected by r to the output at connect
+// instead of the client's own, e.g. timers over UDP to a real-time path
+// while everything else goes over TCP
+//
+// the output is set up like a sink added with WithSink, and like those a
+// metric can be routed to several; sinks added with WithSink only get
+// what the client sends itself, the metrics no route selected
+func WithRoute(r Route, connect string, opts ...Option) Option {
+	return func(hc *HekaClient) error {
+		if err := r.check(); err != nil {
+			return fmt.Errorf("route: %s", err)
+		}
+		if err := WithSink(connect, opts...)(hc); err != nil {
+			return err
+		}
+		hc.sinks[len(hc.sinks)-1].route = &r
+		return nil
+	}
+}
+
+// unrouted returns the samples no route selected, which the client sends
+// itself
+func (hc *HekaClient) unrouted(samples []sample) []sample {
+	var routes []*Route
+	for _, s := range hc.sinks {
+		if s.route != nil {
+			routes = append(routes, s.route)
+		}
+	}
+	if len(routes) == 0 {
+		return samples
+	}
+	var rest []sample
+next:
+	for _, s := range samples {
+		for _, r := range routes {
+			if r.match(s) {
+				continue next
+			}
+		}
+		rest = append(rest, s)
+	}
+	return rest
+}
@@ -0,0 +1,110 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sentMetrics returns the sorted metric names in the messages s was sent,
+// with the kind and statistic of timer fields cut off
+func sentMetrics(t *testing.T, s *recordingSender) []string {
+	seen := make(map[string]bool)
+	for _, msg := range s.messages(t) {
+		for _, name := range fieldNames(msg) {
+			if i := strings.Index(name, ".timer."); i >= 0 {
+				name = name[:i]
+			}
+			seen[name] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRoutes(t *testing.T) {
+	own, sink, timers, api, dbCounters := &recordingSender{}, &recordingSender{}, &recordingSender{}, &recordingSender{}, &recordingSender{}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(own),
+		WithSink("udp://127.0.0.1:5565", WithSender(sink)),
+		WithRoute(Route{Kinds: []string{"timers"}}, "udp://127.0.0.1:5566", WithSender(timers)),
+		// overlaps the timers route for 'api.latency'
+		WithRoute(Route{Names: []string{"api.*"}}, "udp://127.0.0.1:5567", WithSender(api)),
+		// both the name and the kind must match
+		WithRoute(Route{Names: []string{"db.*"}, Kinds: []string{"counters"}}, "udp://127.0.0.1:5568", WithSender(dbCounters)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("api.requests", r).Inc(1)
+	metrics.GetOrRegisterTimer("api.latency", r).Update(time.Millisecond)
+	metrics.GetOrRegisterTimer("db.latency", r).Update(time.Millisecond)
+	metrics.GetOrRegisterCounter("db.queries", r).Inc(1)
+	// matches no route
+	metrics.GetOrRegisterCounter("jobs", r).Inc(1)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		s    *recordingSender
+		want []string
+	}{
+		{"client", own, []string{"jobs"}},
+		{"sink", sink, []string{"jobs"}},
+		{"timers route", timers, []string{"api.latency", "db.latency"}},
+		{"api route", api, []string{"api.latency", "api.requests"}},
+		{"db counters route", dbCounters, []string{"db.queries"}},
+	} {
+		if got := sentMetrics(t, test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRouteCheck(t *testing.T) {
+	for _, r := range []Route{
+		{Names: []string{"api.["}},
+		{Kinds: []string{"timer"}},
+	} {
+		if _, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithRoute(r, "udp://127.0.0.1:5566")); err == nil || !strings.HasPrefix(err.Error(), "route: ") {
+			t.Errorf("%+v: got %v, expected a route error", r, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Sender writes encoded messages, it's the same as the Heka client's Sender
+// so those can be used directly
+//
+// SendMessage is called with the client's send lock held and b is only
+// valid until it returns
+type Sender interface {
+	SendMessage(b []byte) error
+	Close()
+}
+
+// SendError is returned by the built in senders when a frame couldn't be
+// written in full, or the reply to it read
+//
+// on a stream connection a frame that was partly written, or a connection
+// the peer reset, leaves Heka's stream parser mid-record, so the client
+// drops such connections rather than writing anything else on them
+type SendError struct {
+	// Wrote and Len are the bytes of the frame written, and its length
+	Wrote, Len int
+	// Err is the underlying write error, nil for a short write without one
+	Err error
+	// Reply is set when the frame was written but the peer's reply to it,
+	// for protocols that have one, couldn't be read or made no sense,
+	// leaving the connection out of step
+	Reply bool
+}
+
+func (e *SendError) Error() string {
+	switch {
+	case e.Reply:
+		return fmt.Sprintf("reply: %s", e.Err)
+	case e.Short() && e.Err == nil:
+		return fmt.Sprintf("short write: wrote %d of %d bytes", e.Wrote, e.Len)
+	case e.Short():
+		return fmt.Sprintf("short write: wrote %d of %d bytes: %s", e.Wrote, e.Len, e.Err)
+	case e.Reset():
+		return fmt.Sprintf("connection reset: %s", e.Err)
+	}
+	return fmt.Sprintf("write: %s", e.Err)
+}
+
+// Short reports whether part, but not all, of the frame was written
+func (e *SendError) Short() bool {
+	return e.Err == nil || (e.Wrote > 0 && e.Wrote < e.Len)
+}
+
+// Reset reports whether the peer reset or closed the connection
+func (e *SendError) Reset() bool {
+	err := e.Err
+	if op, ok := err.(*net.OpError); ok {
+		err = op.Err
+	}
+	if sc, ok := err.(*os.SyscallError); ok {
+		err = sc.Err
+	}
+	return err == syscall.ECONNRESET || err == syscall.EPIPE
+}
+
+// brokenStream reports whether err leaves the connection unusable for
+// further frames
+func brokenStream(err error) bool {
+	e, ok := err.(*SendError)
+	return ok && (e.Short() || e.Reset() || e.Reply)
+}
+
+// netSender is a Sender over a net.Conn that bounds each write with
+// a deadline
+type netSender struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// DialFunc connects to addr on network like net.Dialer's DialContext, which
+// it can be, the client's write timeout is the deadline of ctx
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dial connects to addr with the DialFunc set with WithDialer or
+// WithDialFunc, or net.DialTimeout, the write timeout bounding the dial
+func (hc *HekaClient) dial(network, addr string) (net.Conn, error) {
+	if hc.dialFunc == nil {
+		return net.DialTimeout(network, addr, hc.timeout)
+	}
+	ctx := context.Background()
+	if hc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hc.timeout)
+		defer cancel()
+	}
+	return hc.dialFunc(ctx, network, addr)
+}
+
+// dialSender connects to addr, the client's write timeout bounds the dial
+// and every later write, 0 means no timeout
+func dialSender(hc *HekaClient, network, addr string) (*netSender, error) {
+	conn, err := hc.dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &netSender{conn: conn, timeout: hc.timeout}, nil
+}
+
+func (s *netSender) SendMessage(b []byte) error {
+	if s.timeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	n, err := s.conn.Write(b)
+	if err != nil || n != len(b) {
+		return &SendError{Wrote: n, Len: len(b), Err: err}
+	}
+	return nil
+}
+
+// contextSender is a Sender whose writes can be abandoned when a context is
+// done
+type contextSender interface {
+	SendMessageContext(ctx context.Context, b []byte) error
+}
+
+// aLongTimeAgo is a write deadline that fails pending writes at once
+var aLongTimeAgo = time.Unix(1, 0)
+
+// SendMessageContext is SendMessage ending at the deadline of ctx, if it's
+// sooner than the timeout, or as soon as ctx is canceled, with ctx.Err() as
+// the SendError's Err
+func (s *netSender) SendMessageContext(ctx context.Context, b []byte) error {
+	deadline, ok := ctx.Deadline()
+	if s.timeout > 0 {
+		if t := time.Now().Add(s.timeout); !ok || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	s.conn.SetWriteDeadline(deadline)
+	if ctx.Done() == nil {
+		// ctx can't be canceled, the deadline is enough
+		n, err := s.conn.Write(b)
+		if err != nil || n != len(b) {
+			return &SendError{Wrote: n, Len: len(b), Err: err}
+		}
+		return nil
+	}
+
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			s.conn.SetWriteDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	n, err := s.conn.Write(b)
+	close(done)
+	// the deadline mustn't be moved back after the write
+	<-exited
+	if err != nil || n != len(b) {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return &SendError{Wrote: n, Len: len(b), Err: err}
+	}
+	return nil
+}
+
+func (s *netSender) Close() {
+	s.conn.Close()
+}
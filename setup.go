@@ -0,0 +1,157 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Setup is the quick start for a new service: it creates a registry whose
+// metrics are named 'prefix.<name>', registers the Go runtime's memory
+// statistics and the process metrics below in it, and sends it to connect
+// every interval, returning the registry to register the service's own
+// metrics in and the client, for Stop
+//
+//	r, _, err := hekametrics.Setup("myapp", "tcp://127.0.0.1:5565")
+//
+// the process metrics are 'process.uptime', in seconds, and, where /proc
+// exists, 'process.fds', the open file descriptors; the 'Type' is prefix,
+// opts are those of NewHekaClient
+//
+// go-metrics keeps the runtime statistics in package globals, so only the
+// registry of the first Setup call gets them, later calls only register the
+// process metrics
+func Setup(prefix, connect string, opts ...Option) (metrics.Registry, *HekaClient, error) {
+	hc, err := NewHekaClient(connect, prefix, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := metrics.NewRegistry()
+	if prefix != "" {
+		r = prefixedRegistry{r, prefix + "."}
+	}
+	memStats := false
+	runtimeStats.Do(func() {
+		metrics.RegisterRuntimeMemStats(r)
+		memStats = true
+	})
+	p := newProcessMetrics(r, hc.clock)
+	go func() {
+		tick := hc.clock.NewTicker(hc.interval)
+		defer tick.Stop()
+		for {
+			if memStats {
+				metrics.CaptureRuntimeMemStatsOnce(r)
+			}
+			p.capture()
+			select {
+			case <-hc.stop:
+				return
+			case <-tick.C():
+			}
+		}
+	}()
+	go hc.LogHeka(r, 0)
+	return r, hc, nil
+}
+
+// runtimeStats registers the runtime statistics in the first Setup registry
+var runtimeStats sync.Once
+
+// prefixedRegistry prepends prefix to the names of the metrics registered
+// in it
+//
+// Each returns the names with the prefix, so Get and Unregister only add it
+// to names without it, and a name read back from the registry finds its
+// metric
+type prefixedRegistry struct {
+	metrics.Registry
+	prefix string
+}
+
+func (r prefixedRegistry) name(name string) string {
+	if strings.HasPrefix(name, r.prefix) {
+		return name
+	}
+	return r.prefix + name
+}
+
+func (r prefixedRegistry) Get(name string) interface{} {
+	return r.Registry.Get(r.name(name))
+}
+
+func (r prefixedRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	return r.Registry.GetOrRegister(r.prefix+name, i)
+}
+
+func (r prefixedRegistry) Register(name string, i interface{}) error {
+	return r.Registry.Register(r.prefix+name, i)
+}
+
+func (r prefixedRegistry) Unregister(name string) {
+	r.Registry.Unregister(r.name(name))
+}
+
+// processMetrics are the gauges Setup keeps up to date besides the runtime
+// statistics
+type processMetrics struct {
+	clock  Clock
+	start  time.Time
+	uptime metrics.Gauge
+	// fds is nil without /proc
+	fds metrics.Gauge
+}
+
+func newProcessMetrics(r metrics.Registry, clock Clock) *processMetrics {
+	p := &processMetrics{clock: clock, start: clock.Now(), uptime: metrics.NewGauge()}
+	r.Register("process.uptime", p.uptime)
+	if _, err := ioutil.ReadDir(procFds); err == nil {
+		p.fds = metrics.NewGauge()
+		r.Register("process.fds", p.fds)
+	}
+	return p
+}
+
+// procFds lists the open file descriptors on Linux
+const procFds = "/proc/self/fd"
+
+func (p *processMetrics) capture() {
+	p.uptime.Update(int64(p.clock.Now().Sub(p.start) / time.Second))
+	if p.fds == nil {
+		return
+	}
+	if fds, err := ioutil.ReadDir(procFds); err == nil {
+		p.fds.Update(int64(len(fds)))
+	}
+}
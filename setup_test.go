@@ -0,0 +1,83 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"testing"
+	"time"
+)
+
+func TestPrefixedRegistryNames(t *testing.T) {
+	r := prefixedRegistry{metrics.NewRegistry(), "app."}
+	c := metrics.NewCounter()
+	r.Register("requests", c)
+
+	var names []string
+	r.Each(func(name string, _ interface{}) { names = append(names, name) })
+	if len(names) != 1 || names[0] != "app.requests" {
+		t.Fatalf("Each names %v, want [app.requests]", names)
+	}
+	if r.Get("requests") != c || r.Get("app.requests") != c {
+		t.Errorf("Get doesn't find the counter by either name")
+	}
+	r.Unregister(names[0])
+	if r.Get("requests") != nil {
+		t.Errorf("Unregister of a name from Each left the counter registered")
+	}
+}
+
+func TestSetupStaleUnregister(t *testing.T) {
+	clock := newTestClock()
+	rec := &recordingSender{}
+	r, hc, err := Setup("app", "tcp://127.0.0.1:5565", WithSender(rec), WithClock(clock),
+		WithInterval(time.Hour), WithStaleMetrics(time.Minute, StaleOmit, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Stop()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	hits := metrics.GetOrRegisterCounter("hits", r)
+
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	hits.Inc(1)
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Get("requests") != nil {
+		t.Errorf("stale counter still registered")
+	}
+	if r.Get("hits") == nil {
+		t.Errorf("changed counter unregistered")
+	}
+}
@@ -0,0 +1,135 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// Signer is the identity and key Heka protobuf messages are signed with,
+// matched against the signers configured on Heka's inputs, e.g.
+//
+//	[TcpInput.signer.metrics_1]
+//	hmac_key = "..."
+//
+// for Name 'metrics' and KeyVersion 1
+type Signer struct {
+	// Name is the signer Heka knows the key by
+	Name string
+	// Key is the HMAC key shared with Heka
+	Key string
+	// KeyVersion tells apart the keys of a signer, so a new key can be
+	// added to Heka, rolled out to clients and the old one retired
+	KeyVersion uint32
+	// Hash is 'md5', the default, or 'sha1'
+	Hash string
+}
+
+// maxSignerName keeps a signed header within message.MAX_HEADER_SIZE
+const maxSignerName = 200
+
+// signing signs messages for the streamEncoder
+type signing struct {
+	// header holds the hash function, signer and key version fields of a
+	// signed Header, the same for every message
+	header []byte
+	mac    hash.Hash
+}
+
+// newSigning checks s and prepares the header fields and HMAC for it
+func newSigning(s Signer) (*signing, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("signer: name must not be empty")
+	}
+	if len(s.Name) > maxSignerName {
+		return nil, fmt.Errorf("signer: name must be at most %d bytes, got %d", maxSignerName, len(s.Name))
+	}
+	if s.Key == "" {
+		return nil, fmt.Errorf("signer: key must not be empty")
+	}
+	var fn uint64
+	h := md5.New
+	switch s.Hash {
+	case "", "md5":
+	case "sha1":
+		fn, h = 1, sha1.New
+	default:
+		return nil, fmt.Errorf("signer: unknown hash '%s', try 'md5' or 'sha1'", s.Hash)
+	}
+
+	var n [binary.MaxVarintLen64]byte
+	b := []byte{0x18} // Header.hmac_hash_function, field 3 varint
+	b = append(b, n[:binary.PutUvarint(n[:], fn)]...)
+	b = append(b, 0x22) // Header.hmac_signer, field 4 bytes
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(s.Name)))]...)
+	b = append(b, s.Name...)
+	b = append(b, 0x28) // Header.hmac_key_version, field 5 varint
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(s.KeyVersion))]...)
+	return &signing{header: b, mac: hmac.New(h, []byte(s.Key))}, nil
+}
+
+// appendHeader appends the signing fields for the marshaled message m to
+// header
+func (s *signing) appendHeader(header, m []byte) []byte {
+	s.mac.Reset()
+	s.mac.Write(m)
+	header = append(header, s.header...)
+	header = append(header, 0x32, byte(s.mac.Size())) // Header.hmac, field 6 bytes
+	return s.mac.Sum(header)
+}
+
+// WithSigner signs Heka protobuf messages as s, see SetSigner to change
+// the key while running
+func WithSigner(s Signer) Option {
+	return func(hc *HekaClient) error {
+		return hc.SetSigner(s)
+	}
+}
+
+// SetSigner signs the messages sent from now on as s, e.g. with the next
+// KeyVersion when keys are rotated, the flush in progress, if any, finishes
+// with the previous one
+//
+// sinks added with WithSink have signers of their own
+func (hc *HekaClient) SetSigner(s Signer) error {
+	signing, err := newSigning(s)
+	if err != nil {
+		return err
+	}
+	hc.mu.Lock()
+	hc.encoder.signing = signing
+	hc.mu.Unlock()
+	return nil
+}
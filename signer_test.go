@@ -0,0 +1,116 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"github.com/mozilla-services/heka/message"
+	"hash"
+	"testing"
+)
+
+func TestSignedHeader(t *testing.T) {
+	for _, c := range []struct {
+		hash string
+		fn   message.Header_HmacHashFunction
+		h    func() hash.Hash
+	}{
+		{"", message.Header_MD5, md5.New},
+		{"md5", message.Header_MD5, md5.New},
+		{"sha1", message.Header_SHA1, sha1.New},
+	} {
+		signer := Signer{Name: "metrics", Key: "secret", KeyVersion: 300, Hash: c.hash}
+		s, err := newSigning(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := newStreamEncoder()
+		e.signing = s
+		var b []byte
+		if err = e.EncodeMessageStream(testMessage(2), &b); err != nil {
+			t.Fatal(err)
+		}
+
+		header, m, rest := readRecord(t, b)
+		if len(rest) != 0 {
+			t.Errorf("%d bytes after the record", len(rest))
+		}
+		if header.HmacHashFunction == nil || *header.HmacHashFunction != c.fn {
+			t.Errorf("%s: hash function %v, want %v", c.hash, header.HmacHashFunction, c.fn)
+		}
+		if header.GetHmacSigner() != "metrics" || header.GetHmacKeyVersion() != 300 {
+			t.Errorf("%s: signer %s version %d", c.hash, header.GetHmacSigner(), header.GetHmacKeyVersion())
+		}
+		mac := hmac.New(c.h, []byte("secret"))
+		mac.Write(m)
+		if !hmac.Equal(header.GetHmac(), mac.Sum(nil)) {
+			t.Errorf("%s: hmac %x, want %x", c.hash, header.GetHmac(), mac.Sum(nil))
+		}
+	}
+}
+
+func TestSignerLongName(t *testing.T) {
+	name := make([]byte, maxSignerName)
+	for i := range name {
+		name[i] = 'n'
+	}
+	s, err := newSigning(Signer{Name: string(name), Key: "k", KeyVersion: 1 << 31, Hash: "sha1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newStreamEncoder()
+	e.signing = s
+	var b []byte
+	if err = e.EncodeMessageStream(testMessage(300), &b); err != nil {
+		t.Fatal(err)
+	}
+	if hlen := int(b[1]); hlen > message.MAX_HEADER_SIZE {
+		t.Fatalf("header of %d bytes", hlen)
+	}
+	header, _, _ := readRecord(t, b)
+	if header.GetHmacSigner() != string(name) || header.GetHmacKeyVersion() != 1<<31 {
+		t.Errorf("header fields changed")
+	}
+}
+
+func TestSignerErrors(t *testing.T) {
+	for _, s := range []Signer{
+		{Key: "k"},
+		{Name: "n"},
+		{Name: "n", Key: "k", Hash: "sha256"},
+		{Name: string(make([]byte, maxSignerName+1)), Key: "k"},
+	} {
+		if _, err := newSigning(s); err == nil {
+			t.Errorf("%+v: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/mozilla-services/heka/message"
+	"net/url"
+)
+
+// sink is an extra output added with WithSink, it's a client of its own so
+// it keeps its own connection, encoding settings and Stats
+type sink struct {
+	connect string
+	*HekaClient
+	// route selects the metrics sent to a sink added with WithRoute, nil
+	// for one getting everything the client sends
+	route *Route
+}
+
+// SinkError wraps an error sending to a sink added with WithSink
+type SinkError struct {
+	Connect string
+	Err     error
+}
+
+func (e *SinkError) Error() string {
+	connect := e.Connect
+	if u, err := url.Parse(connect); err == nil {
+		connect = redacted(u)
+	}
+	return fmt.Sprintf("sink '%s': %s", connect, e.Err)
+}
+
+// Unwrap returns Err
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// WithSink sends every message to the output at connect as well, e.g. a
+// local file shadowing the Heka connection during a migration
+//
+// the sink is set up like a client of its own from connect, with any
+// settings embedded in it, and opts, which only affect how messages are
+// encoded and sent: messages are built once, by the client they're added to
+func WithSink(connect string, opts ...Option) Option {
+	return func(hc *HekaClient) error {
+		s, err := NewHekaClient(connect, hc.msgtype, opts...)
+		if err != nil {
+			return fmt.Errorf("sink: %s", err)
+		}
+		hc.sinks = append(hc.sinks, sink{connect: connect, HekaClient: s})
+		return nil
+	}
+}
+
+// SinkStats returns a copy of the counters of each sink added with WithSink,
+// keyed by its connect string
+func (hc *HekaClient) SinkStats() map[string]Stats {
+	stats := make(map[string]Stats, len(hc.sinks))
+	for _, s := range hc.sinks {
+		stats[s.connect] = s.Stats()
+	}
+	return stats
+}
+
+// shareSinks gives the sinks the client's static fields and partitions,
+// whose fields their encoders tell apart from metrics, Uuid source, for
+// split messages, and DialFunc unless they have their own
+func (hc *HekaClient) shareSinks() {
+	for _, s := range hc.sinks {
+		s.fields = hc.fields
+		s.partitions = hc.partitions
+		s.uuid = hc.uuid
+		if s.dialFunc == nil {
+			s.dialFunc = hc.dialFunc
+		}
+	}
+}
+
+// fanOut sends out[i] to sink i, for every sink in turn, a failing sink
+// doesn't keep the others from sending
+//
+// it returns the first error, as a *SinkError
+func (hc *HekaClient) fanOut(ctx context.Context, out [][]*message.Message) error {
+	var first error
+	for i, s := range hc.sinks {
+		start := s.clock.Now()
+		s.mu.Lock()
+		s.ctx = ctx
+		err := s.send(out[i])
+		s.ctx = nil
+		s.log.expire()
+		s.mu.Unlock()
+		s.countFlush(start, s.clock.Now().Sub(start))
+		if err != nil && first == nil {
+			first = &SinkError{s.connect, err}
+		}
+	}
+	return first
+}
@@ -0,0 +1,141 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"math"
+	"sync"
+)
+
+// StalePolicy says what happens to metrics that stopped changing, see
+// WithStaleMetrics
+type StalePolicy int
+
+const (
+	// StaleFlag sends stale metrics with a '<name>.stale' field of 1, the
+	// default
+	StaleFlag StalePolicy = iota
+	// StaleOmit leaves stale metrics out of messages until they change
+	StaleOmit
+)
+
+var stalePolicies = map[string]StalePolicy{"flag": StaleFlag, "omit": StaleOmit}
+
+// ParseStalePolicy parses 'flag' or 'omit'
+func ParseStalePolicy(s string) (StalePolicy, error) {
+	p, ok := stalePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown stale policy '%s', try 'flag' or 'omit'", s)
+	}
+	return p, nil
+}
+
+// staleness tracks when the metrics of each registry last changed
+type staleness struct {
+	// after is how long a metric must stay unchanged to be stale, 0 turns
+	// tracking off
+	after      int64
+	policy     StalePolicy
+	unregister bool
+
+	mu   sync.Mutex
+	seen map[int]map[string]*lastChange
+}
+
+// lastChange is a metric's value and when it was first seen
+type lastChange struct {
+	value mark
+	at    int64
+}
+
+// mark is what tells whether a metric changed: the count of counters,
+// meters, histograms and timers, and the value of gauges
+type mark struct {
+	i int64
+	f uint64
+}
+
+func markOf(metric interface{}) mark {
+	switch metric := metric.(type) {
+	case metrics.Counter:
+		return mark{i: metric.Count()}
+	case metrics.Gauge:
+		return mark{i: metric.Value()}
+	case metrics.GaugeFloat64:
+		return mark{f: math.Float64bits(metric.Value())}
+	case metrics.Histogram:
+		return mark{i: metric.Count()}
+	case metrics.Meter:
+		return mark{i: metric.Count()}
+	case metrics.Timer:
+		return mark{i: metric.Count()}
+	}
+	return mark{}
+}
+
+// check sets stale on the samples of the registry at index reg, read at,
+// that haven't changed for long enough, unregistering them from r if asked
+// to, and returns the samples to send
+//
+// metrics no longer in the registry are forgotten, so a metric registered
+// again starts afresh
+func (st *staleness) check(reg int, r metrics.Registry, samples []sample, at int64) []sample {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.seen == nil {
+		st.seen = make(map[int]map[string]*lastChange)
+	}
+	last := st.seen[reg]
+	next := make(map[string]*lastChange, len(samples))
+	kept := samples[:0]
+	for _, s := range samples {
+		m := markOf(s.metric)
+		c := last[s.name]
+		if c == nil || c.value != m {
+			c = &lastChange{value: m, at: at}
+		}
+		next[s.name] = c
+		if at-c.at >= st.after {
+			s.stale = true
+			if st.unregister {
+				r.Unregister(s.name)
+				delete(next, s.name)
+			}
+			if st.policy == StaleOmit {
+				continue
+			}
+		}
+		kept = append(kept, s)
+	}
+	st.seen[reg] = next
+	return kept
+}
@@ -0,0 +1,138 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// staleFlush flushes r with hc and returns the sorted field names of the
+// message sent
+func staleFlush(t *testing.T, hc *HekaClient, s *recordingSender, r metrics.Registry) []string {
+	s.sent = nil
+	if err := hc.Flush(r); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.messages(t)
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages sent, want 1", len(msgs))
+	}
+	names := fieldNames(msgs[0])
+	sort.Strings(names)
+	return names
+}
+
+func staleClient(t *testing.T, policy StalePolicy, unregister bool) (*HekaClient, *recordingSender, *testClock) {
+	clock := newTestClock()
+	s := &recordingSender{}
+	hc, err := NewHekaClient("tcp://127.0.0.1:5565", "stats", WithSender(s), WithClock(clock),
+		WithStaleMetrics(time.Minute, policy, unregister))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hc, s, clock
+}
+
+func TestStaleFlag(t *testing.T) {
+	hc, s, clock := staleClient(t, StaleFlag, false)
+	r := metrics.NewRegistry()
+	requests := metrics.GetOrRegisterCounter("requests", r)
+	depth := metrics.GetOrRegisterGauge("depth", r)
+
+	for i, step := range []struct {
+		advance time.Duration
+		change  func()
+		want    []string
+	}{
+		{0, nil, []string{"depth", "requests"}},
+		{30 * time.Second, nil, []string{"depth", "requests"}},
+		// a minute unchanged
+		{30 * time.Second, nil, []string{"depth", "depth.stale", "requests", "requests.stale"}},
+		{time.Minute, func() { requests.Inc(1) }, []string{"depth", "depth.stale", "requests"}},
+		// a gauge set to a new value changed, its count doesn't matter
+		{0, func() { depth.Update(3) }, []string{"depth", "requests"}},
+		{time.Minute, func() { depth.Update(3) }, []string{"depth", "depth.stale", "requests", "requests.stale"}},
+	} {
+		clock.advance(step.advance)
+		if step.change != nil {
+			step.change()
+		}
+		if got := staleFlush(t, hc, s, r); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("flush %d: got %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestStaleOmit(t *testing.T) {
+	hc, s, clock := staleClient(t, StaleOmit, false)
+	r := metrics.NewRegistry()
+	requests := metrics.GetOrRegisterCounter("requests", r)
+	metrics.GetOrRegisterCounter("errors", r)
+
+	staleFlush(t, hc, s, r)
+	clock.advance(time.Minute)
+	requests.Inc(1)
+	if got := staleFlush(t, hc, s, r); !reflect.DeepEqual(got, []string{"requests"}) {
+		t.Errorf("got %v, expected the unchanged 'errors' left out", got)
+	}
+	if r.Get("errors") == nil {
+		t.Errorf("'errors' unregistered without being asked to")
+	}
+}
+
+func TestStaleUnregister(t *testing.T) {
+	hc, s, clock := staleClient(t, StaleOmit, true)
+	r := metrics.NewRegistry()
+	requests := metrics.GetOrRegisterCounter("requests", r)
+	metrics.GetOrRegisterCounter("errors", r)
+
+	staleFlush(t, hc, s, r)
+	clock.advance(time.Minute)
+	requests.Inc(1)
+	staleFlush(t, hc, s, r)
+	if r.Get("errors") != nil {
+		t.Fatalf("stale 'errors' still registered")
+	}
+	if r.Get("requests") == nil {
+		t.Fatalf("changed 'requests' unregistered")
+	}
+
+	// registered again, it starts afresh rather than being stale at once
+	metrics.GetOrRegisterCounter("errors", r)
+	clock.advance(30 * time.Second)
+	requests.Inc(1)
+	if got := staleFlush(t, hc, s, r); !reflect.DeepEqual(got, []string{"errors", "requests"}) {
+		t.Errorf("got %v, want [errors requests]", got)
+	}
+}
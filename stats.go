@@ -0,0 +1,136 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time copy of a HekaClient's internal counters
+type Stats struct {
+	Connected         bool          `json:"connected"`
+	Connects          int64         `json:"connects"`
+	ConnectErrors     int64         `json:"connect_errors"`
+	Flushes           int64         `json:"flushes"`
+	MessagesSent      int64         `json:"messages_sent"`
+	BytesSent         int64         `json:"bytes_sent"`
+	SendErrors        int64         `json:"send_errors"`
+	EncodeErrors      int64         `json:"encode_errors"`
+	LastFlush         time.Time     `json:"last_flush"`
+	LastFlushDuration time.Duration `json:"last_flush_duration_ns"`
+	NonFiniteValues   int64         `json:"non_finite_values"`
+	ShortWrites       int64         `json:"short_writes"`
+	ConnResets        int64         `json:"conn_resets"`
+	DuplicateFields   int64         `json:"duplicate_fields"`
+	InvalidNames      int64         `json:"invalid_names"`
+}
+
+// Stats returns a copy of the client's counters
+func (hc *HekaClient) Stats() Stats {
+	hc.statsMu.Lock()
+	s := hc.stats
+	hc.statsMu.Unlock()
+	s.NonFiniteValues = atomic.LoadInt64(&hc.build.nonFinite)
+	s.InvalidNames = atomic.LoadInt64(&hc.build.invalid)
+	return s
+}
+
+// PublishExpvar publishes the client's Stats under name with the expvar
+// package, making them visible on /debug/vars
+//
+// like expvar.Publish it panics if name is already registered
+func (hc *HekaClient) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return hc.Stats()
+	}))
+}
+
+func (hc *HekaClient) countConnect(err error) {
+	hc.statsMu.Lock()
+	if err != nil {
+		hc.stats.ConnectErrors++
+		hc.stats.Connected = false
+	} else {
+		hc.stats.Connects++
+		hc.stats.Connected = true
+	}
+	hc.statsMu.Unlock()
+}
+
+// countSent counts a write of size bytes holding n messages
+func (hc *HekaClient) countSent(n, size int) {
+	hc.statsMu.Lock()
+	hc.stats.MessagesSent += int64(n)
+	hc.stats.BytesSent += int64(size)
+	hc.statsMu.Unlock()
+}
+
+func (hc *HekaClient) countSendError() {
+	hc.statsMu.Lock()
+	hc.stats.SendErrors++
+	hc.statsMu.Unlock()
+}
+
+// countWriteFailure classifies a failed SendMessage
+func (hc *HekaClient) countWriteFailure(err error) {
+	e, ok := err.(*SendError)
+	if !ok {
+		return
+	}
+	hc.statsMu.Lock()
+	if e.Short() {
+		hc.stats.ShortWrites++
+	} else if e.Reset() {
+		hc.stats.ConnResets++
+	}
+	hc.statsMu.Unlock()
+}
+
+func (hc *HekaClient) countDuplicate() {
+	hc.statsMu.Lock()
+	hc.stats.DuplicateFields++
+	hc.statsMu.Unlock()
+}
+
+func (hc *HekaClient) countEncodeError() {
+	hc.statsMu.Lock()
+	hc.stats.EncodeErrors++
+	hc.statsMu.Unlock()
+}
+
+func (hc *HekaClient) countFlush(start time.Time, d time.Duration) {
+	hc.statsMu.Lock()
+	hc.stats.Flushes++
+	hc.stats.LastFlush = start
+	hc.stats.LastFlushDuration = d
+	hc.statsMu.Unlock()
+}
@@ -0,0 +1,65 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"testing"
+)
+
+func TestStatsMessagesSent(t *testing.T) {
+	registry := func(name string) metrics.Registry {
+		r := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+		return r
+	}
+
+	for _, connect := range []string{"tcp://127.0.0.1:5565", "udp://127.0.0.1:5565"} {
+		s := &recordingSender{}
+		hc, err := NewHekaClient(connect, "stats", WithSender(s), WithRegistries(registry("b"), registry("c")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = hc.Flush(registry("a")); err != nil {
+			t.Fatal(err)
+		}
+		bytes := 0
+		for _, b := range s.sent {
+			bytes += len(b)
+		}
+		stats := hc.Stats()
+		if stats.MessagesSent != 3 {
+			t.Errorf("%s: %d messages sent in %d writes, expected 3", connect, stats.MessagesSent, len(s.sent))
+		}
+		if stats.BytesSent != int64(bytes) {
+			t.Errorf("%s: %d bytes counted, %d written", connect, stats.BytesSent, bytes)
+		}
+	}
+}
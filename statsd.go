@@ -0,0 +1,133 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"bytes"
+	"github.com/mozilla-services/heka/message"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// statsdPacketSize keeps datagrams under a typical ethernet MTU
+const statsdPacketSize = 1432
+
+// encodeStatsd renders each metric field of msg as a statsd gauge,
+// 'name:value|g', one per line
+//
+// go-metrics has already aggregated everything, so counts and timer stats
+// are sent as gauges; re-sending them as counters or timings would make the
+// statsd relay aggregate them a second time
+func encodeStatsd(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	b := (*buf)[:0]
+	for _, f := range msg.GetFields() {
+		if hc.isStatic(f) {
+			continue
+		}
+		var value string
+		switch f.GetValueType() {
+		case message.Field_INTEGER:
+			if len(f.GetValueInteger()) == 0 {
+				continue
+			}
+			value = strconv.FormatInt(f.GetValueInteger()[0], 10)
+		case message.Field_DOUBLE:
+			if len(f.GetValueDouble()) == 0 {
+				continue
+			}
+			v := f.GetValueDouble()[0]
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			continue
+		}
+		if len(value) > 0 && value[0] == '-' {
+			// a leading sign means adjust the gauge, reset it to 0 first
+			b = append(b, statsdName(f.GetName())...)
+			b = append(b, ":0|g\n"...)
+		}
+		b = append(b, statsdName(f.GetName())...)
+		b = append(b, ':')
+		b = append(b, value...)
+		b = append(b, "|g\n"...)
+	}
+	*buf = b
+	return nil
+}
+
+// statsdName replaces the characters statsd uses as separators
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func dialStatsd(hc *HekaClient, u *url.URL) (Sender, error) {
+	s, err := dialSender(hc, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return statsdSender{s}, nil
+}
+
+// statsdSender splits a batch of lines into datagrams of at most
+// statsdPacketSize, breaking only between lines
+type statsdSender struct {
+	Sender
+}
+
+func (s statsdSender) SendMessage(b []byte) error {
+	for len(b) > 0 {
+		n := len(b)
+		if n > statsdPacketSize {
+			n = bytes.LastIndexByte(b[:statsdPacketSize], '\n') + 1
+			if n == 0 {
+				// a single oversized line, send it alone
+				n = bytes.IndexByte(b, '\n') + 1
+				if n == 0 {
+					n = len(b)
+				}
+			}
+		}
+		if err := s.Sender.SendMessage(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
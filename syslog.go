@@ -0,0 +1,109 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// syslogFacility is local0
+const syslogFacility = 16
+
+// encodeSyslog wraps the JSON rendering of msg in an RFC 5424 syslog frame,
+// with the message's Logger as APP-NAME and Type as MSGID
+//
+// Severity is used as the syslog severity when it's in the syslog range,
+// otherwise informational
+func encodeSyslog(hc *HekaClient, msg *message.Message, buf *[]byte) error {
+	severity := msg.GetSeverity()
+	if severity < 0 || severity > 7 {
+		severity = 6
+	}
+	b := append((*buf)[:0], '<')
+	b = strconv.AppendInt(b, int64(syslogFacility*8+severity), 10)
+	b = append(b, ">1 "...)
+	b = time.Unix(0, msg.GetTimestamp()).UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, ' ')
+	b = append(b, syslogToken(msg.GetHostname(), 255)...)
+	b = append(b, ' ')
+	b = append(b, syslogToken(msg.GetLogger(), 48)...)
+	b = append(b, ' ')
+	if msg.Pid != nil {
+		b = strconv.AppendInt(b, int64(msg.GetPid()), 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, ' ')
+	b = append(b, syslogToken(msg.GetType(), 32)...)
+	b = append(b, " - "...)
+	b, err := appendJSON(b, msg)
+	*buf = b
+	return err
+}
+
+// syslogToken makes s a valid header field: printable ASCII without spaces,
+// at most max long and '-' when empty
+func syslogToken(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// dialSyslogTCP frames each message with RFC 6587 octet counting, so the
+// receiver can find message boundaries in the stream
+func dialSyslogTCP(hc *HekaClient, u *url.URL) (Sender, error) {
+	s, err := dialSender(hc, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &octetCountingSender{Sender: s}, nil
+}
+
+type octetCountingSender struct {
+	Sender
+	buf []byte
+}
+
+func (s *octetCountingSender) SendMessage(b []byte) error {
+	s.buf = strconv.AppendInt(s.buf[:0], int64(len(b)), 10)
+	s.buf = append(s.buf, ' ')
+	s.buf = append(s.buf, b...)
+	return s.Sender.SendMessage(s.buf)
+}
@@ -0,0 +1,87 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package tomlconfig reads hekametrics settings from TOML files, in the same
+style as Heka's own configuration, keeping the TOML parser out of the
+hekametrics package for applications configuring it otherwise
+
+	connect = "tcp://heka:5565"
+	type = "stats"
+	interval = "10s"
+	exclude = ["debug.*"]
+
+	[fields]
+	environment = "production"
+
+keys are spelled like the hekametrics.Config struct tags and durations are
+strings
+
+	client, err := tomlconfig.Load("/etc/myapp/hekametrics.toml")
+*/
+package tomlconfig
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/imgix/hekametrics"
+	"path/filepath"
+)
+
+// ReadFile reads a hekametrics.Config from the TOML file at path, or the
+// JSON file if path ends in '.json'
+func ReadFile(path string) (*hekametrics.Config, error) {
+	if filepath.Ext(path) == ".json" {
+		return hekametrics.ReadConfigFile(path)
+	}
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	c, err := hekametrics.ConfigFromMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Load creates a HekaClient configured by the file at path, see ReadFile
+//
+// opts are applied after the settings from the file
+func Load(path string, opts ...hekametrics.Option) (*hekametrics.HekaClient, error) {
+	c, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hc, err := hekametrics.NewFromConfig(c, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return hc, nil
+}
@@ -0,0 +1,132 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package tomlconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes body to name in a new temporary directory
+func writeConfig(t *testing.T, name, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "tomlconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestReadFile(t *testing.T) {
+	path, done := writeConfig(t, "heka.toml", `
+connect = "tcp://127.0.0.1:5565"
+type = "stats"
+interval = "10s"
+exclude = ["debug.*"]
+percentiles = [0.5, 0.99]
+
+[fields]
+environment = "production"
+`)
+	defer done()
+
+	c, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Connect != "tcp://127.0.0.1:5565" || c.Type != "stats" || c.Interval != 10*time.Second {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Exclude, []string{"debug.*"}) {
+		t.Errorf("exclude: got %v", c.Exclude)
+	}
+	if !reflect.DeepEqual(c.Percentiles, []float64{0.5, 0.99}) {
+		t.Errorf("percentiles: got %v", c.Percentiles)
+	}
+	if c.Fields["environment"] != "production" {
+		t.Errorf("fields: got %v", c.Fields)
+	}
+}
+
+func TestReadFileJSON(t *testing.T) {
+	path, done := writeConfig(t, "heka.json", `{"connect": "tcp://127.0.0.1:5565", "interval": "5s"}`)
+	defer done()
+
+	c, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Connect != "tcp://127.0.0.1:5565" || c.Interval != 5*time.Second {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path, done := writeConfig(t, "heka.toml", "connect = \"tcp://127.0.0.1:5565\"\ntype = \"metrics\"\n")
+	defer done()
+
+	if _, err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadBadFile(t *testing.T) {
+	for _, test := range []struct {
+		body, err string
+	}{
+		{`connect = `, "heka.toml: "},
+		{"connect = \"tcp://127.0.0.1:5565\"\nnosuch = 1", "nosuch: unknown setting"},
+		{"connect = \"tcp://127.0.0.1:5565\"\ninterval = 10", "interval: durations must be strings"},
+		{"connect = \"tcp://127.0.0.1:5565\"\npercentiles = [2.0]", "config: percentiles"},
+		{`type = "stats"`, "config: connect"},
+	} {
+		path, done := writeConfig(t, "heka.toml", test.body)
+		_, err := Load(path)
+		done()
+		if err == nil {
+			t.Errorf("%q: expected an error", test.body)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got '%s', expected '%s'", test.body, err, test.err)
+		}
+	}
+
+	if _, err := Load("/nonexistent/heka.toml"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,109 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"sort"
+)
+
+// topN keeps only the largest counters and timers of a flush
+type topN struct {
+	// n is how many counters, and how many timers, are kept, 0 keeps all
+	n int
+	// always are the patterns of metrics kept regardless, they don't count
+	// towards n
+	always []string
+}
+
+// rank returns the value counters and timers are ranked by, the count (or
+// the delta with CounterDelta) and the mean duration, and false for other
+// metrics, which are always kept
+func (t *topN) rank(s sample, counters CounterMode) (float64, bool) {
+	switch metric := s.metric.(type) {
+	case metrics.Counter:
+		if counters == CounterDelta {
+			return float64(delta(s.prev, metric.Count())), true
+		}
+		return float64(metric.Count()), true
+	case metrics.Timer:
+		return metric.Mean(), true
+	}
+	return 0, false
+}
+
+// ranked is a sample and its rank
+type ranked struct {
+	i     int
+	value float64
+}
+
+// byValue sorts ranked samples largest first
+type byValue []ranked
+
+func (r byValue) Len() int           { return len(r) }
+func (r byValue) Less(i, j int) bool { return r[i].value > r[j].value }
+func (r byValue) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// top returns samples without the counters and timers outside the n
+// largest of each, keeping their order
+func (hc *HekaClient) top(samples []sample) []sample {
+	t := &hc.topN
+	if t.n <= 0 {
+		return samples
+	}
+	var counters, timers byValue
+	keep := make([]bool, len(samples))
+	for i, s := range samples {
+		v, ok := t.rank(s, hc.build.counters)
+		if !ok || matchAny(t.always, s.name) {
+			keep[i] = true
+			continue
+		}
+		if _, ok := s.metric.(metrics.Counter); ok {
+			counters = append(counters, ranked{i, v})
+		} else {
+			timers = append(timers, ranked{i, v})
+		}
+	}
+	for _, r := range []byValue{counters, timers} {
+		sort.Stable(r)
+		for j := 0; j < len(r) && j < t.n; j++ {
+			keep[r[j].i] = true
+		}
+	}
+	kept := make([]sample, 0, len(samples))
+	for i, s := range samples {
+		if keep[i] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,91 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"github.com/mozilla-services/heka/message"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// UUIDFunc returns the 16 byte Uuid set on each message
+//
+// it's called from concurrent flushes, so it must be safe for concurrent use
+type UUIDFunc func() []byte
+
+// NewRandomUUID returns a random (version 4) UUID read from crypto/rand,
+// it's the default UUIDFunc
+func NewRandomUUID() []byte {
+	u := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, u); err != nil {
+		logger.Printf("uuid: reading random bytes: %s\n", err)
+	}
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return u
+}
+
+// NameUUIDNamespace is the namespace of the Uuids set with WithNameUUIDs
+var NameUUIDNamespace = []byte{0x6b, 0x3e, 0x0c, 0x52, 0x8f, 0x1d, 0x4a, 0x7e, 0x9b, 0x41, 0x2d, 0xc6, 0x05, 0xe8, 0x73, 0x9a}
+
+// NewNameUUID returns the name based (version 5) UUID of name in namespace,
+// which is itself a UUID
+func NewNameUUID(namespace, name []byte) []byte {
+	h := sha1.New()
+	h.Write(namespace)
+	h.Write(name)
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50 // version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return u
+}
+
+// nameUUID derives the Uuid of a message stamped ts from the hostname, ts
+// and the number of messages the client has built
+func (hc *HekaClient) nameUUID(ts int64) []byte {
+	n := atomic.AddInt64(&hc.built, 1)
+	name := append([]byte(hc.hostname), '/')
+	name = strconv.AppendInt(name, ts, 10)
+	name = append(name, '/')
+	name = strconv.AppendInt(name, n, 10)
+	return NewNameUUID(NameUUIDNamespace, name)
+}
+
+// partUUID returns the Uuid of part i of msg when it's split, derived from
+// msg's own with WithNameUUIDs
+func (hc *HekaClient) partUUID(msg *message.Message, i int) []byte {
+	if !hc.nameUUIDs {
+		return hc.uuid()
+	}
+	return NewNameUUID(msg.GetUuid(), []byte{byte('a' + i)})
+}
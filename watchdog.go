@@ -0,0 +1,104 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"net/url"
+)
+
+// FailureFunc is told when flushes failed to send failures times in a row,
+// err being the last send error
+//
+// it's called from the flushing goroutine, with the client locked, and must
+// not block or flush
+type FailureFunc func(failures int, err error)
+
+// watchdog counts consecutive flushes whose send failed, guarded by hc.mu
+type watchdog struct {
+	failures int
+
+	// notifyAt is how many failures call notify, see WithFailureWatchdog
+	notifyAt int
+	notify   FailureFunc
+
+	// spillAt is how many failures start writing messages to path, see
+	// WithFailureFile
+	spillAt int
+	path    string
+	file    Sender
+}
+
+// watch records the outcome of sending msgs, err being what send returned,
+// hc.mu must be held
+func (hc *HekaClient) watch(msgs []*message.Message, err error) {
+	w := &hc.watchdog
+	if w.notifyAt == 0 && w.spillAt == 0 {
+		return
+	}
+	if _, ok := err.(encodeError); ok || err == nil {
+		w.failures = 0
+		return
+	}
+	w.failures++
+	if w.failures == w.notifyAt && w.notify != nil {
+		w.notify(w.failures, err)
+	}
+	if w.spillAt > 0 && w.failures >= w.spillAt {
+		hc.spill(msgs)
+	}
+}
+
+// spill appends msgs to the failure file as a Heka stream, hc.mu must be
+// held
+func (hc *HekaClient) spill(msgs []*message.Message) {
+	w := &hc.watchdog
+	if w.file == nil {
+		s, err := openFile(hc, &url.URL{Scheme: "file", Path: w.path})
+		if err != nil {
+			hc.log.Printf("Failure file: [error] %s\n", err)
+			return
+		}
+		w.file = s
+	}
+	var frame []byte
+	for _, msg := range msgs {
+		if err := encodeHeka(hc, msg, &frame); err != nil {
+			hc.log.Printf("Failure file: [error] encode message: %s\n", err)
+			continue
+		}
+		if err := w.file.SendMessage(frame); err != nil {
+			hc.log.Printf("Failure file: [error] %s\n", err)
+			w.file.Close()
+			w.file = nil
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"sync"
+)
+
+// gaugeWindow accumulates gauge values read between flushes, so a flush
+// can report their minimum, maximum and average over its interval rather
+// than only their value at that instant
+//
+// only gauges are sampled: counters, meters, histograms and timers already
+// summarize everything since the previous flush
+type gaugeWindow struct {
+	mu    sync.Mutex
+	stats map[int]map[string]*windowStats
+}
+
+// windowStats are the values a gauge had in a window
+type windowStats struct {
+	min, max, sum float64
+	n             int
+}
+
+func (w *windowStats) add(v float64) {
+	if w.n == 0 || v < w.min {
+		w.min = v
+	}
+	if w.n == 0 || v > w.max {
+		w.max = v
+	}
+	w.sum += v
+	w.n++
+}
+
+// gaugeValue returns the value of metric if it's a gauge
+func gaugeValue(metric interface{}) (float64, bool) {
+	switch metric := metric.(type) {
+	case metrics.Gauge:
+		return float64(metric.Value()), true
+	case metrics.GaugeFloat64:
+		return metric.Value(), true
+	}
+	return 0, false
+}
+
+// sample adds the current values of the gauges in the registry at index
+// reg in a flush
+func (w *gaugeWindow) sample(reg int, r metrics.Registry, match func(string) bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stats == nil {
+		w.stats = make(map[int]map[string]*windowStats)
+	}
+	stats := w.stats[reg]
+	if stats == nil {
+		stats = make(map[string]*windowStats)
+		w.stats[reg] = stats
+	}
+	r.Each(func(name string, i interface{}) {
+		if match != nil && !match(name) {
+			return
+		}
+		v, ok := gaugeValue(i)
+		if !ok {
+			return
+		}
+		s := stats[name]
+		if s == nil {
+			s = &windowStats{}
+			stats[name] = s
+		}
+		s.add(v)
+	})
+}
+
+// close adds the gauge samples of the registry at index reg to its window,
+// sets window on them and starts a new one
+//
+// the windows of skipped samples, see WithSampleRate, carry on into the new
+// one, covering every interval up to the flush that sends them
+func (w *gaugeWindow) close(reg int, samples []sample) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stats == nil {
+		w.stats = make(map[int]map[string]*windowStats)
+	}
+	stats := w.stats[reg]
+	delete(w.stats, reg)
+	for i := range samples {
+		s := &samples[i]
+		v, ok := gaugeValue(s.metric)
+		if !ok {
+			continue
+		}
+		ws := stats[s.name]
+		if ws == nil {
+			ws = &windowStats{}
+		}
+		ws.add(v)
+		if s.skip {
+			if w.stats[reg] == nil {
+				w.stats[reg] = make(map[string]*windowStats)
+			}
+			w.stats[reg][s.name] = ws
+			continue
+		}
+		s.window = ws
+	}
+}
+
+// sampleGauges reads the gauges of r and the extra registries into the
+// current window
+func (hc *HekaClient) sampleGauges(r metrics.Registry) {
+	var match func(string) bool
+	if !hc.filter.empty() {
+		match = hc.filter.match
+	}
+	for i, reg := range hc.allRegistries(r) {
+		hc.window.sample(i, reg, match)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build zmq
+// +build zmq
+
+/***** BEGIN LICENSE BLOCK *****
+
+# Author: David Birdsong (david@imgix.com)
+# Copyright (c) 2014, Zebrafish Labs Inc.
+# All rights reserved.
+#
+# Redistribution and use in source and binary forms, with or without
+# modification, are permitted provided that the following conditions are met:
+#
+# 	Redistributions of source code must retain the above copyright notice,
+# 	this list of conditions and the following disclaimer.
+#
+# 	Redistributions in binary form must reproduce the above copyright notice,
+# 	this list of conditions and the following disclaimer in the documentation
+# 	and/or other materials provided with the distribution.
+#
+# THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+# AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+# IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+# ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+# LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+# CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+# SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+# INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+# CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+# ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+# POSSIBILITY OF SUCH DAMAGE.
+# ***** END LICENSE BLOCK *****/
+
+package hekametrics
+
+import (
+	zmq "github.com/pebbe/zmq4"
+	"net/url"
+)
+
+// the ZeroMQ outputs need github.com/pebbe/zmq4 and libzmq, so they're only
+// built with the 'zmq' build tag
+func init() {
+	schemes["zmq"] = scheme{dial: dialZMQ(zmq.PUSH), encode: encodeHeka, heka: true, payload: true}
+	schemes["zmq+pub"] = scheme{dial: dialZMQ(zmq.PUB), encode: encodeHeka, heka: true, payload: true}
+}
+
+// zmqSender sends each framed message as a single ZeroMQ message
+type zmqSender struct {
+	sock *zmq.Socket
+}
+
+// dialZMQ returns a dial func connecting a socket of type t to the tcp
+// endpoint at the host and port of the connect string, the receiving side
+// binds
+func dialZMQ(t zmq.Type) func(*HekaClient, *url.URL) (Sender, error) {
+	return func(hc *HekaClient, u *url.URL) (Sender, error) {
+		sock, err := zmq.NewSocket(t)
+		if err != nil {
+			return nil, err
+		}
+		// don't hold up Close, or the process exiting, on unsent messages
+		sock.SetLinger(0)
+		if hc.timeout > 0 {
+			sock.SetSndtimeo(hc.timeout)
+		}
+		if err = sock.Connect("tcp://" + u.Host); err != nil {
+			sock.Close()
+			return nil, err
+		}
+		return &zmqSender{sock}, nil
+	}
+}
+
+func (s *zmqSender) SendMessage(b []byte) error {
+	n, err := s.sock.SendBytes(b, 0)
+	if err != nil || n != len(b) {
+		return &SendError{Wrote: n, Len: len(b), Err: err}
+	}
+	return nil
+}
+
+func (s *zmqSender) Close() {
+	s.sock.Close()
+}